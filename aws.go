@@ -0,0 +1,410 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the small subset of the AWS SDK needed by the Route53 and
+// Secrets Manager integrations: the standard credential chain and Signature V4
+// request signing. The updater is built from a GOPATH with govendor'd packages,
+// and the SDK (along with its dependency tree) would dwarf the rest of the vendor
+// folder to issue a handful of REST calls.
+
+// awsSharedLifetime is the time credentials loaded from the shared credentials
+// file are cached for, after which the file is read anew to pick up rotations.
+const awsSharedLifetime = 5 * time.Minute
+
+// awsCredentials is a set of AWS access credentials.
+type awsCredentials struct {
+	accessKey string    // Access key ID
+	secretKey string    // Secret access key
+	token     string    // Session token for temporary credentials
+	expires   time.Time // Expiration time of the cached credentials
+}
+
+// awsCredentialChain retrieves AWS credentials from the standard sources, in
+// order: environment variables, the shared credentials file, the ECS task role
+// and finally the EC2 instance metadata service. Credentials are cached until
+// shortly before they expire, those from the shared file for awsSharedLifetime.
+type awsCredentialChain struct {
+	cached *awsCredentials // Last retrieved credentials from a cacheable source
+	lock   sync.Mutex
+}
+
+// retrieve returns a valid set of AWS credentials from the first source having
+// them available.
+func (c *awsCredentialChain) retrieve() (*awsCredentials, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cached != nil && time.Until(c.cached.expires) > time.Minute {
+		return c.cached, nil
+	}
+	// Environment variables take precedence above all
+	if key, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); key != "" && secret != "" {
+		return &awsCredentials{accessKey: key, secretKey: secret, token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	// Fall back to the shared credentials file
+	creds, err := awsSharedCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		creds.expires = time.Now().Add(awsSharedLifetime)
+		c.cached = creds
+		return creds, nil
+	}
+	// Use the task role if running in an ECS container
+	if creds, err = awsContainerCredentials(); err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		c.cached = creds
+		return creds, nil
+	}
+	// Lastly try the instance metadata service if running on EC2
+	if creds, err = awsInstanceCredentials(); err != nil {
+		return nil, fmt.Errorf("no AWS credentials found: %v", err)
+	}
+	c.cached = creds
+	return creds, nil
+}
+
+// awsSharedCredentials loads the credentials of the configured profile from the
+// shared AWS credentials file, returning nil if the file does not exist.
+func awsSharedCredentials() (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		section string
+		creds   = new(awsCredentials)
+		scanner = bufio.NewScanner(file)
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]); key {
+		case "aws_access_key_id":
+			creds.accessKey = value
+		case "aws_secret_access_key":
+			creds.secretKey = value
+		case "aws_session_token":
+			creds.token = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return nil, nil
+	}
+	return creds, nil
+}
+
+// awsMetadataEndpoint is the base URL of the EC2 instance metadata service.
+const awsMetadataEndpoint = "http://169.254.169.254/latest"
+
+// awsContainerEndpoint is the host of the ECS task credentials endpoint, which
+// relative credential URIs are resolved against.
+const awsContainerEndpoint = "http://169.254.170.2"
+
+// awsMetadataClient returns an HTTP client for the link local metadata services,
+// which are only reachable directly, never through the configured proxy.
+func awsMetadataClient() *http.Client {
+	return &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{}}
+}
+
+// awsMetadataToken retrieves a session token for the IMDSv2 metadata service.
+func awsMetadataToken(client *http.Client) (string, error) {
+	req, _ := http.NewRequest("PUT", awsMetadataEndpoint+"/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := awsMetadataGet(client, req)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// awsInstanceCredentials retrieves the temporary credentials of the IAM role
+// attached to the EC2 instance, via the IMDSv2 metadata service.
+func awsInstanceCredentials() (*awsCredentials, error) {
+	direct := awsMetadataClient()
+
+	token, err := awsMetadataToken(direct)
+	if err != nil {
+		return nil, err
+	}
+	req, _ := http.NewRequest("GET", awsMetadataEndpoint+"/meta-data/iam/security-credentials/", nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := awsMetadataGet(direct, req)
+	if err != nil {
+		return nil, err
+	}
+	req, _ = http.NewRequest("GET", awsMetadataEndpoint+"/meta-data/iam/security-credentials/"+strings.TrimSpace(string(role)), nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	blob, err := awsMetadataGet(direct, req)
+	if err != nil {
+		return nil, err
+	}
+	return awsParseCredentials(blob)
+}
+
+// awsContainerCredentials retrieves the temporary credentials of the task role
+// when running in an ECS container (or an EKS pod with an identity association),
+// returning nil if no container credentials endpoint was configured.
+func awsContainerCredentials() (*awsCredentials, error) {
+	var endpoint string
+	switch {
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "":
+		endpoint = awsContainerEndpoint + os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		endpoint = os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	default:
+		return nil, nil
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid container credentials endpoint: %v", err)
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if path := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); path != "" {
+		blob, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read container authorization token: %v", err)
+		}
+		token = strings.TrimSpace(string(blob))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	blob, err := awsMetadataGet(awsMetadataClient(), req)
+	if err != nil {
+		return nil, fmt.Errorf("container credentials retrieval failed: %v", err)
+	}
+	return awsParseCredentials(blob)
+}
+
+// awsInstanceRegion retrieves the region of the EC2 instance from the metadata
+// service.
+func awsInstanceRegion() (string, error) {
+	direct := awsMetadataClient()
+
+	token, err := awsMetadataToken(direct)
+	if err != nil {
+		return "", err
+	}
+	req, _ := http.NewRequest("GET", awsMetadataEndpoint+"/meta-data/placement/region", nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	region, err := awsMetadataGet(direct, req)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(region)), nil
+}
+
+// awsParseCredentials decodes the temporary credentials returned by the instance
+// and container metadata services.
+func awsParseCredentials(blob []byte) (*awsCredentials, error) {
+	var creds struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(blob, &creds); err != nil {
+		return nil, fmt.Errorf("invalid role credentials: %v", err)
+	}
+	return &awsCredentials{
+		accessKey: creds.AccessKeyID,
+		secretKey: creds.SecretAccessKey,
+		token:     creds.Token,
+		expires:   creds.Expiration,
+	}, nil
+}
+
+// awsMetadataGet executes a request against the instance metadata service.
+func awsMetadataGet(client *http.Client, req *http.Request) ([]byte, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	blob, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service returned %s", res.Status)
+	}
+	return blob, nil
+}
+
+// awsRequest executes an AWS API request, signed with Signature Version 4 using
+// the credentials retrieved from the chain.
+func awsRequest(client *http.Client, chain *awsCredentialChain, region string, service string, req *http.Request, body []byte) ([]byte, error) {
+	creds, err := chain.retrieve()
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		req.ContentLength = int64(len(body))
+	}
+	signV4(req, body, creds, region, service, time.Now())
+	return apiCall(client, req)
+}
+
+// signV4 signs an AWS API request with Signature Version 4.
+func signV4(req *http.Request, body []byte, creds *awsCredentials, region string, service string, now time.Time) {
+	var (
+		amzdate = now.UTC().Format("20060102T150405Z")
+		date    = amzdate[:8]
+		payload = sha256.Sum256(body)
+	)
+	req.Header.Set("X-Amz-Date", amzdate)
+	if creds.token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.token)
+	}
+	// Assemble the canonical request from the signed headers
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		awsCanonicalQuery(req),
+		canonHeaders.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	// Derive the signing key and sign the request
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := awsHMAC([]byte("AWS4"+creds.secretKey), date)
+	key = awsHMAC(key, region)
+	key = awsHMAC(key, service)
+	key = awsHMAC(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKey, scope, signed, hex.EncodeToString(awsHMAC(key, toSign))))
+}
+
+// awsCanonicalQuery returns the canonical, sorted and RFC 3986 encoded query
+// string of a request.
+func awsCanonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape URI encodes a string as required by Signature Version 4.
+func awsEscape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			out.WriteByte(c)
+		} else {
+			fmt.Fprintf(&out, "%%%02X", c)
+		}
+	}
+	return out.String()
+}
+
+// awsHMAC computes the HMAC-SHA256 of the data with the given key.
+func awsHMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
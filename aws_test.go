@@ -0,0 +1,110 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that requests are signed as specified by Signature Version 4, using the
+// vectors of the AWS test suite (get-vanilla, get-vanilla-query-order-key-case
+// and post-x-www-form-urlencoded).
+func TestSignV4(t *testing.T) {
+	var (
+		creds = &awsCredentials{accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+		now   = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		scope = "AKIDEXAMPLE/20150830/us-east-1/service/aws4_request"
+	)
+	tests := []struct {
+		method  string
+		url     string
+		headers map[string]string
+		body    string
+		signed  string
+		sig     string
+	}{
+		{
+			method: "GET",
+			url:    "https://example.amazonaws.com/",
+			signed: "host;x-amz-date",
+			sig:    "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			method: "GET",
+			url:    "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signed: "host;x-amz-date",
+			sig:    "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			method:  "POST",
+			url:     "https://example.amazonaws.com/",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:    "Param1=value1",
+			signed:  "content-type;host;x-amz-date",
+			sig:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("%s %s: failed to create request: %v", tt.method, tt.url, err)
+		}
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		req.Header.Set("User-Agent", "ignored") // Unsigned headers must not matter
+		signV4(req, []byte(tt.body), creds, "us-east-1", "service", now)
+
+		want := "AWS4-HMAC-SHA256 Credential=" + scope + ", SignedHeaders=" + tt.signed + ", Signature=" + tt.sig
+		if have := req.Header.Get("Authorization"); have != want {
+			t.Errorf("%s %s: authorization mismatch:\nhave %s\nwant %s", tt.method, tt.url, have, want)
+		}
+		if date := req.Header.Get("X-Amz-Date"); date != "20150830T123600Z" {
+			t.Errorf("%s %s: date mismatch: have %s, want %s", tt.method, tt.url, date, "20150830T123600Z")
+		}
+	}
+}
+
+// Tests that the session token of temporary credentials is sent and signed.
+func TestSignV4SessionToken(t *testing.T) {
+	creds := &awsCredentials{accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", token: "TOKEN"}
+
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	if token := req.Header.Get("X-Amz-Security-Token"); token != "TOKEN" {
+		t.Errorf("session token mismatch: have %q, want %q", token, "TOKEN")
+	}
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date;x-amz-security-token, Signature=564660f640e535dfb2d7d90e91b97b91515c30bfedf0a5ff2564c9e5352e9f12"
+	if have := req.Header.Get("Authorization"); have != want {
+		t.Errorf("authorization mismatch:\nhave %s\nwant %s", have, want)
+	}
+}
+
+// Tests that query strings are canonicalized as required by Signature Version 4.
+func TestAWSCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", ""},
+		{"b=2&a=1", "a=1&b=2"},
+		{"a=2&a=1", "a=1&a=2"},
+		{"name=www.example.com.&type=A", "name=www.example.com.&type=A"},
+		{"key=a%20b%2Bc%2F~", "key=a%20b%2Bc%2F~"},
+		{"key=a+b", "key=a%20b"},
+		{"empty=", "empty="},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "https://route53.amazonaws.com/?"+tt.query, nil)
+		if have := awsCanonicalQuery(req); have != tt.want {
+			t.Errorf("%q: canonical query mismatch: have %q, want %q", tt.query, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// awsSecret is a CloudFlare API token stored in AWS Secrets Manager or the SSM
+// Parameter Store, read with credentials from the standard AWS chain (typically
+// the instance or task role), so no static secret has to be deployed.
+type awsSecret struct {
+	client  *http.Client        // HTTP client to execute the API calls with
+	creds   *awsCredentialChain // Source of the credentials to sign requests with
+	service string              // Service storing the secret (secretsmanager or ssm)
+	region  string              // Region of the service to query
+	name    string              // Name or ARN of the secret or parameter
+	field   string              // JSON field of the secret holding the token (empty = all)
+}
+
+// newAWSSecret creates an AWS token source for a secret given as
+// secretsmanager:name[#field] or ssm:name. The region is taken from the ARN,
+// from the environment or from the instance metadata, in that order.
+func newAWSSecret(client *http.Client, secret string) (*awsSecret, error) {
+	parts := strings.SplitN(secret, ":", 2)
+	if len(parts) != 2 || parts[1] == "" || (parts[0] != "secretsmanager" && parts[0] != "ssm") {
+		return nil, fmt.Errorf("invalid AWS secret, expected secretsmanager:name[#field] or ssm:name: %s", secret)
+	}
+	s := &awsSecret{client: client, creds: new(awsCredentialChain), service: parts[0], name: parts[1]}
+	if s.service == "secretsmanager" {
+		if index := strings.LastIndex(s.name, "#"); index >= 0 {
+			s.name, s.field = s.name[:index], s.name[index+1:]
+		}
+	}
+	// Resolve the region the secret lives in
+	if arn := strings.Split(s.name, ":"); len(arn) > 3 && arn[0] == "arn" {
+		s.region = arn[3]
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_REGION")
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		region, err := awsInstanceRegion()
+		if err != nil {
+			return nil, fmt.Errorf("no AWS region found, set $AWS_REGION: %v", err)
+		}
+		s.region = region
+	}
+	return s, nil
+}
+
+// load implements a credentials token source, retrieving the secret value.
+func (s *awsSecret) load() (string, error) {
+	var (
+		target   string
+		payload  interface{}
+		override string
+	)
+	switch s.service {
+	case "secretsmanager":
+		target, payload = "secretsmanager.GetSecretValue", map[string]string{"SecretId": s.name}
+		override = os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER")
+	case "ssm":
+		target, payload = "AmazonSSM.GetParameter", map[string]interface{}{"Name": s.name, "WithDecryption": true}
+		override = os.Getenv("AWS_ENDPOINT_URL_SSM")
+	}
+	body, _ := json.Marshal(payload)
+
+	// The endpoint may be overridden the same way as with the AWS SDKs
+	endpoint := "https://" + s.service + "." + s.region + ".amazonaws.com/"
+	if override == "" {
+		override = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if override != "" {
+		endpoint = override
+	}
+	req, err := http.NewRequest("POST", endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	res, err := awsRequest(s.client, s.creds, s.region, s.service, req, body)
+	if err != nil {
+		return "", fmt.Errorf("%s retrieval of %s failed: %v", s.service, s.name, err)
+	}
+	var reply struct {
+		SecretString string `json:"SecretString"`
+		Parameter    struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := json.Unmarshal(res, &reply); err != nil {
+		return "", fmt.Errorf("invalid %s reply: %v", s.service, err)
+	}
+	if s.service == "ssm" {
+		return reply.Parameter.Value, nil
+	}
+	if s.field == "" {
+		return reply.SecretString, nil
+	}
+	// Secrets with multiple key/value pairs are stored as JSON objects
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(reply.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %v", s.name, err)
+	}
+	value, ok := fields[s.field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %s field", s.name, s.field)
+	}
+	return value, nil
+}
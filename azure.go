@@ -0,0 +1,284 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// azureEndpoint is the base URL of the Azure Resource Manager API.
+const azureEndpoint = "https://management.azure.com"
+
+// azureVersion is the version of the Azure DNS API to use.
+const azureVersion = "2018-05-01"
+
+// azureProvider is the Azure DNS provider, updating the record sets of public
+// DNS zones through the Resource Manager API. Requests are authenticated with a
+// service principal's client secret if configured in the standard environment
+// variables, or with the managed identity of the Azure host otherwise.
+type azureProvider struct {
+	client *http.Client // HTTP client to execute the API calls with
+	group  string       // Resource path of the group holding the DNS zones
+
+	tenant string // Directory (tenant) ID of the service principal
+	id     string // Client ID of the service principal or managed identity
+	secret string // Client secret of the service principal (empty = managed identity)
+
+	token   string    // Current OAuth2 access token
+	expires time.Time // Expiration time of the current access token
+	lock    sync.Mutex
+}
+
+// newAzureProvider creates an Azure DNS provider for the zones in the given
+// subscription and resource group.
+func newAzureProvider(client *http.Client, subscription string, group string) (*azureProvider, error) {
+	if subscription == "" {
+		subscription = os.Getenv("AZURE_SUBSCRIPTION_ID")
+	}
+	if subscription == "" || group == "" {
+		return nil, errors.New("no DNS zone location specified, use -azure-subscription and -azure-resource-group")
+	}
+	p := &azureProvider{
+		client: client,
+		group:  "/subscriptions/" + url.PathEscape(subscription) + "/resourceGroups/" + url.PathEscape(group),
+		tenant: os.Getenv("AZURE_TENANT_ID"),
+		id:     os.Getenv("AZURE_CLIENT_ID"),
+		secret: os.Getenv("AZURE_CLIENT_SECRET"),
+	}
+	if p.secret != "" && (p.tenant == "" || p.id == "") {
+		return nil, errors.New("client secret requires AZURE_TENANT_ID and AZURE_CLIENT_ID")
+	}
+	return p, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *azureProvider) String() string {
+	return "azure"
+}
+
+// azureRecordSet is the mutable part of a record set in the Azure DNS API.
+type azureRecordSet struct {
+	Properties struct {
+		TTL         int            `json:"TTL"`
+		ARecords    []azureRecord  `json:"ARecords,omitempty"`
+		AAAARecords []azureRecord6 `json:"AAAARecords,omitempty"`
+	} `json:"properties"`
+}
+
+// azureRecord is a single IPv4 address of an Azure DNS record set.
+type azureRecord struct {
+	Address string `json:"ipv4Address"`
+}
+
+// azureRecord6 is a single IPv6 address of an Azure DNS record set.
+type azureRecord6 struct {
+	Address string `json:"ipv6Address"`
+}
+
+// values returns the addresses of the record set (only one type is ever set).
+func (s *azureRecordSet) values() []string {
+	var values []string
+	for _, rec := range s.Properties.ARecords {
+		values = append(values, rec.Address)
+	}
+	for _, rec := range s.Properties.AAAARecords {
+		values = append(values, rec.Address)
+	}
+	return values
+}
+
+// setValues replaces the addresses of the record set of the given type.
+func (s *azureRecordSet) setValues(kind string, values []string) {
+	s.Properties.ARecords, s.Properties.AAAARecords = nil, nil
+	for _, value := range values {
+		if kind == "A" {
+			s.Properties.ARecords = append(s.Properties.ARecords, azureRecord{value})
+		} else {
+			s.Properties.AAAARecords = append(s.Properties.AAAARecords, azureRecord6{value})
+		}
+	}
+}
+
+// verify implements provider, checking that the DNS zones of all domains are
+// accessible with the configured identity.
+func (p *azureProvider) verify(domains []*domain) error {
+	if _, err := p.authorize(); err != nil {
+		if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 400") {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+		logErrorf("Failed to authenticate with Azure: %v", err)
+		return nil
+	}
+	for _, host := range domains {
+		zone, err := hostZone(host)
+		if err != nil {
+			return err
+		}
+		if err := p.call("GET", p.zonePath(zone), nil, nil); err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 404") {
+				return fmt.Errorf("zone of %s not accessible (missing DNS Zone Contributor role?): %v", host, err)
+			}
+			logErrorf("Failed to verify zone of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record set of a domain to hold the
+// given address, according to the configured policy for multiple records.
+func (p *azureProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = hostZone(host); err != nil {
+			return false, err
+		}
+	}
+	// Record sets are addressed by their name relative to the zone
+	endpoint := p.zonePath(host.zoneID) + "/" + kind + "/" + url.PathEscape(relativeName(host.name, host.zoneID))
+
+	var (
+		set    azureRecordSet
+		exists = true
+	)
+	if err := p.call("GET", endpoint, nil, &set); err != nil {
+		if !strings.Contains(err.Error(), "HTTP status 404") {
+			return false, fmt.Errorf("record set retrieval failed: %v", err)
+		}
+		if !*createFlag {
+			return false, fmt.Errorf("no %s record set found for %s, use -create to add it", kind, host)
+		}
+		exists = false
+	}
+	// Assemble the new record set, Azure DNS having no notion of automatic TTLs
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl == 1 {
+		ttl = 300
+	}
+	values := []string{address}
+	if exists {
+		if values, err = mergeValues(set.values(), address, previous); err != nil {
+			return false, err
+		}
+		if set.Properties.TTL == ttl && strings.Join(set.values(), ",") == strings.Join(values, ",") {
+			return false, nil
+		}
+	}
+	var update azureRecordSet
+	update.Properties.TTL = ttl
+	update.setValues(kind, values)
+
+	if !exists {
+		if err := p.call("PUT", endpoint, update, nil); err != nil {
+			return false, fmt.Errorf("record set creation failed: %v", err)
+		}
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+		return true, nil
+	}
+	// Patch existing record sets to retain any metadata set on them
+	if err := p.call("PATCH", endpoint, update, nil); err != nil {
+		return false, fmt.Errorf("record set update failed: %v", err)
+	}
+	return true, nil
+}
+
+// zonePath returns the resource path of a DNS zone.
+func (p *azureProvider) zonePath(zone string) string {
+	return p.group + "/providers/Microsoft.Network/dnsZones/" + zone
+}
+
+// call executes an authorized Resource Manager API request.
+func (p *azureProvider) call(method string, path string, payload interface{}, result interface{}) error {
+	token, err := p.authorize()
+	if err != nil {
+		return err
+	}
+	endpoint := azureEndpoint + path + "?api-version=" + azureVersion
+	if simulated(method, endpoint, payload) {
+		return nil
+	}
+	return jsonCall(p.client, method, endpoint, http.Header{"Authorization": {"Bearer " + token}}, payload, result)
+}
+
+// authorize returns a valid OAuth2 access token for the Resource Manager API,
+// requesting a new one if the previous token expired.
+//
+// Only the client credentials grant and the managed identity endpoint are needed,
+// both a single form or query request, so they are issued directly instead of via
+// azure-sdk-for-go, whose module tree would dwarf the rest of the vendor folder.
+func (p *azureProvider) authorize() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.token != "" && time.Until(p.expires) > time.Minute {
+		return p.token, nil
+	}
+	var (
+		client = p.client
+		req    *http.Request
+		err    error
+	)
+	if p.secret != "" {
+		// Service principal, authenticate via the client credentials grant
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", p.id)
+		form.Set("client_secret", p.secret)
+		form.Set("scope", azureEndpoint+"/.default")
+
+		req, err = http.NewRequest("POST", "https://login.microsoftonline.com/"+url.PathEscape(p.tenant)+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		// Managed identity, request a token from the local metadata service
+		query := url.Values{}
+		query.Set("api-version", "2018-02-01")
+		query.Set("resource", azureEndpoint+"/")
+		if p.id != "" {
+			query.Set("client_id", p.id)
+		}
+		req, err = http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+
+		// Metadata is only reachable directly, never through the configured proxy
+		client = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{}}
+	}
+	now := time.Now()
+
+	res, err := apiCall(client, req)
+	if err != nil {
+		return "", fmt.Errorf("access token request failed: %v", err)
+	}
+	// The metadata service reports the expiration as a string, unlike Azure AD
+	var token struct {
+		AccessToken string          `json:"access_token"`
+		ExpiresIn   json.RawMessage `json:"expires_in"`
+	}
+	if err := json.Unmarshal(res, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid access token response: %s", res)
+	}
+	expires, _ := strconv.Atoi(strings.Trim(string(token.ExpiresIn), `"`))
+
+	p.token, p.expires = token.AccessToken, now.Add(time.Duration(expires)*time.Second)
+	return p.token, nil
+}
@@ -0,0 +1,60 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// bogonNetworks are the IPv4 ranges that can never be a valid public address:
+// private, loopback, link-local, documentation, benchmarking, multicast and
+// reserved ones. The carrier-grade NAT range is deliberately missing as that is
+// handled separately by detectCGNAT.
+var bogonNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("127.0.0.0/8"),
+	mustParseCIDR("169.254.0.0/16"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("192.0.2.0/24"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("198.51.100.0/24"),
+	mustParseCIDR("203.0.113.0/24"),
+	mustParseCIDR("224.0.0.0/4"),
+	mustParseCIDR("240.0.0.0/4"),
+}
+
+var (
+	// globalUnicast6 is the IPv6 range currently allocated for global unicast.
+	globalUnicast6 = mustParseCIDR("2000::/3")
+
+	// documentation6 is the IPv6 range reserved for documentation.
+	documentation6 = mustParseCIDR("2001:db8::/32")
+)
+
+// checkBogon verifies that an address is a plausible public address, rejecting
+// anything from the private, loopback, link-local or reserved ranges which no
+// resolution service should ever report.
+func checkBogon(ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		for _, network := range bogonNetworks {
+			if network.Contains(ip4) {
+				return fmt.Errorf("bogon address %s (in %s)", ip, network)
+			}
+		}
+		return nil
+	}
+	if !globalUnicast6.Contains(ip) {
+		return fmt.Errorf("bogon address %s (not in %s)", ip, globalUnicast6)
+	}
+	if documentation6.Contains(ip) {
+		return fmt.Errorf("bogon address %s (in %s)", ip, documentation6)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// Tests that addresses no resolution service should ever report are rejected.
+func TestCheckBogon(t *testing.T) {
+	tests := []struct {
+		address string
+		bogon   bool
+	}{
+		{"8.8.8.8", false},
+		{"1.1.1.1", false},
+		{"100.64.0.1", false}, // Carrier-grade NAT, handled separately
+		{"0.1.2.3", true},
+		{"10.1.2.3", true},
+		{"127.0.0.1", true},
+		{"169.254.1.1", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"172.32.0.1", false},
+		{"192.0.0.1", true},
+		{"192.0.2.1", true},
+		{"192.168.1.1", true},
+		{"198.18.0.1", true},
+		{"198.51.100.1", true},
+		{"203.0.113.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+		{"2a01:4f8::1", false},
+		{"2001:db8::1", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"ff02::1", true},
+		{"4000::1", true},
+	}
+	for _, tt := range tests {
+		if err := checkBogon(net.ParseIP(tt.address)); (err != nil) != tt.bogon {
+			t.Errorf("%s: bogon mismatch: have %v, want bogon %v", tt.address, err, tt.bogon)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// sharedAddressSpace is the RFC 6598 range reserved for carrier-grade NAT.
+var sharedAddressSpace = mustParseCIDR("100.64.0.0/10")
+
+// gatewayMethods are the resolution methods reporting the WAN address of the
+// local router, usable to detect carrier-grade NAT.
+var gatewayMethods = []string{"upnp", "natpmp", "pcp", "fritzbox", "mikrotik"}
+
+// detectCGNAT checks whether the machine seems to be behind carrier-grade NAT,
+// in which case publishing the resolved address is pointless since inbound
+// connections cannot reach it. The address is considered unreachable if it's
+// from the shared address space itself, or if any gateway method from the
+// resolution chain reports a WAN address that is shared, private or different.
+// An empty string is returned if no CGNAT was detected, otherwise the reason.
+func detectCGNAT(address string, chain []string) string {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() == nil {
+		return ""
+	}
+	if sharedAddressSpace.Contains(ip) {
+		return fmt.Sprintf("address %s is in the shared address space %s", address, sharedAddressSpace)
+	}
+	// Cross check against the WAN address reported by the router, if one is known
+	for _, method := range chain {
+		for _, gateway := range gatewayMethods {
+			if method != gateway {
+				continue
+			}
+			wan, err := queryGateway(method, "", false)
+			if err != nil {
+				continue
+			}
+			wanip := net.ParseIP(wan)
+			switch {
+			case wanip == nil:
+				continue
+			case sharedAddressSpace.Contains(wanip):
+				return fmt.Sprintf("router WAN address %s (%s) is in the shared address space %s", wan, method, sharedAddressSpace)
+			case isPrivateIPv4(wanip):
+				return fmt.Sprintf("router WAN address %s (%s) is private", wan, method)
+			case !wanip.Equal(ip):
+				return fmt.Sprintf("router WAN address %s (%s) differs from external %s", wan, method, address)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// isPrivateIPv4 reports whether an IPv4 address is from the RFC 1918 private
+// ranges, which indicates double NAT when reported as a router's WAN address.
+func isPrivateIPv4(ip net.IP) bool {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return false
+	}
+	return ip4[0] == 10 || (ip4[0] == 172 && ip4[1]&0xf0 == 16) || (ip4[0] == 192 && ip4[1] == 168)
+}
+
+// mustParseCIDR parses a CIDR network, panicking on failure.
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
@@ -0,0 +1,566 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+var (
+	zoneIDMatcher = regexp.MustCompile("^[0-9a-f]{32}$")
+)
+
+// cloudflareProvider is the CloudFlare DNS provider, updating records through
+// an authenticated API client.
+type cloudflareProvider struct {
+	api    *cloudflare.API // CloudFlare client to update the records with
+	scoped bool            // Whether the client authenticates with an API token
+}
+
+// newCloudflareProvider creates a CloudFlare DNS provider with the given API
+// credentials.
+func newCloudflareProvider(creds *credentials, client *http.Client) (*cloudflareProvider, error) {
+	api, err := newAPI(creds, client)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudflareProvider{api: api, scoped: creds.scoped()}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *cloudflareProvider) String() string {
+	return "cloudflare"
+}
+
+// verify implements provider, checking the credentials and domain access.
+func (p *cloudflareProvider) verify(domains []*domain) error {
+	return verifyAPI(p.api, p.scoped, domains)
+}
+
+// upsert implements provider, updating a single DNS record.
+func (p *cloudflareProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	return updateDNS(p.api, address, previous, host, kind, ttl)
+}
+
+// newAPI creates an authenticated CloudFlare client, either via a scoped API
+// token (preferred) or via the legacy account email and global API key. The API
+// endpoint is overridden if a custom base URL was configured.
+func newAPI(creds *credentials, client *http.Client) (*cloudflare.API, error) {
+	// Authenticate via a custom transport (which allows rotating the credentials
+	// on the fly) and disable the built in key/email authentication altogether. Rate limited
+	// calls are already retried by the transport, so disable the client's own
+	// retries to avoid multiplying the attempts.
+	authed := *client
+	authed.Transport = &authTransport{base: client.Transport, creds: creds}
+
+	api, err := cloudflare.New("transport", "transport", cloudflare.HTTPClient(&authed), cloudflare.UsingRetryPolicy(0, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	api.SetAuthType(0)
+
+	if *apiURLFlag != "" {
+		if u, err := url.Parse(*apiURLFlag); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid API base URL: %s", *apiURLFlag)
+		}
+		api.BaseURL = strings.TrimSuffix(*apiURLFlag, "/")
+	}
+	return api, nil
+}
+
+// apiContext creates the context of a single CloudFlare API call, bounded by the
+// configured API timeout and cancelled when the in-flight updates are aborted.
+func apiContext() (context.Context, context.CancelFunc) {
+	if *apiTimeoutFlag > 0 {
+		return context.WithTimeout(aborting, *apiTimeoutFlag)
+	}
+	return context.WithCancel(aborting)
+}
+
+// callAPI issues a raw CloudFlare API call under its own deadline, returning the
+// result field of the reply.
+func callAPI(api *cloudflare.API, method string, endpoint string, data interface{}) (json.RawMessage, error) {
+	ctx, cancel := apiContext()
+	defer cancel()
+
+	return api.Raw(ctx, method, endpoint, data, nil)
+}
+
+// rateLimitTransport is an HTTP transport retrying CloudFlare API calls that were
+// rejected due to rate limiting, waiting as long as the server asks via its
+// Retry-After header (or backing off exponentially if it didn't specify).
+//
+// The client is shared with the other providers, whose requests are issued without
+// contexts, so the transport runs each attempt under a context derived from the
+// updater's own lifecycle context (via req.WithContext) as well, bounded by a
+// deadline so that a stalled request cannot hang the update loop, and cancelled
+// on shutdown. CloudFlare calls carry their own per-call deadline on top.
+type rateLimitTransport struct {
+	base    http.RoundTripper // Transport to execute the actual requests with
+	ctx     context.Context   // Lifecycle context all request attempts are bound to
+	retries int               // Maximum number of retries before giving up
+	timeout time.Duration     // Deadline of a single request attempt
+}
+
+// RoundTrip implements http.RoundTripper, executing a single API request.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		res, err := t.roundTrip(req)
+		if err != nil {
+			logDebugf("API call %s %s failed after %v: %v", req.Method, redactURL(req.URL), time.Since(start), err)
+		} else {
+			logDebugf("API call %s %s replied %s in %v%s", req.Method, redactURL(req.URL), res.Status, time.Since(start), rayID(res))
+		}
+		if err != nil || res.StatusCode != http.StatusTooManyRequests || attempt >= t.retries {
+			return res, err
+		}
+		// Rate limited, figure out how much to wait and rewind the request
+		wait := backoff
+		if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+		if wait > time.Minute {
+			wait = time.Minute
+		}
+		backoff *= 2
+
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return res, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return res, nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		res.Body.Close()
+
+		logWarnf("CloudFlare API rate limited, retrying in %v", wait)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-t.ctx.Done():
+			return nil, errors.New("shutting down")
+		}
+	}
+}
+
+// rayID returns the CloudFlare request identifier of a reply for debug logs, to
+// be quoted when reporting issues to CloudFlare support.
+func rayID(res *http.Response) string {
+	if ray := res.Header.Get("Cf-Ray"); ray != "" {
+		return " (ray " + ray + ")"
+	}
+	return ""
+}
+
+// roundTrip executes a single request attempt under the lifecycle context of the
+// transport, bounded by the configured deadline. Any context the request itself
+// carries is honored too. The context is only released when the response body is
+// closed.
+func (t *rateLimitTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(t.ctx, t.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(t.ctx)
+	}
+	unbind := context.AfterFunc(req.Context(), cancel)
+	release := func() {
+		unbind()
+		cancel()
+	}
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: release}
+	return res, nil
+}
+
+// cancelBody is a response body releasing the request context when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+// Close implements io.Closer, closing the body and releasing the context.
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// verifyAPI checks that the configured credentials are valid and that they can
+// access every zone and record about to be maintained. Authentication and
+// permission failures are returned, transient errors are only reported so a
+// flaky network during boot does not stop the updater.
+func verifyAPI(api *cloudflare.API, token bool, domains []*domain) error {
+	// Scoped tokens can be verified directly, having a dedicated endpoint
+	if token {
+		res, err := callAPI(api, "GET", "/user/tokens/verify", nil)
+		if err != nil {
+			if deniedAPI(err) {
+				return fmt.Errorf("token verification failed: %v", err)
+			}
+			logErrorf("Failed to verify API token: %v", err)
+			return nil
+		}
+		var status struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(res, &status); err != nil {
+			return fmt.Errorf("invalid token verification response: %v", err)
+		}
+		if status.Status != "active" {
+			return fmt.Errorf("API token is %s", status.Status)
+		}
+	}
+	// Ensure the zones and records of all the domains are accessible
+	for _, host := range domains {
+		zone, err := resolveZone(api, host)
+		if err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "could not be found") {
+				return fmt.Errorf("zone of %s not accessible (missing Zone:Read permission?): %v", host, err)
+			}
+			logErrorf("Failed to verify zone of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+
+		recs, err := findRecords(api, zone, host.name, "")
+		if err != nil {
+			if deniedAPI(err) {
+				return fmt.Errorf("records of %s not accessible (missing DNS:Edit permission?): %v", host, err)
+			}
+			logErrorf("Failed to verify records of %s: %v", host, err)
+			continue
+		}
+		if len(recs) == 0 && !*createFlag {
+			logWarnf("No DNS records found for %s, use -create to add them", host)
+		}
+	}
+	return nil
+}
+
+// deniedAPI reports whether an API error is an authentication or an authorization
+// failure. CloudFlare client errors are typed, the calls of the other providers
+// embed the HTTP status into the message instead.
+func deniedAPI(err error) bool {
+	var (
+		unauthorized *cloudflare.AuthorizationError
+		forbidden    *cloudflare.AuthenticationError
+	)
+	if errors.As(err, &unauthorized) || errors.As(err, &forbidden) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "HTTP status 401") || strings.Contains(msg, "HTTP status 403")
+}
+
+// resolveZone retrieves the CloudFlare zone ID of a domain, either from its
+// explicit zone setting (name or ID), or by deriving the zone from the domain
+// name itself as the registrable domain according to the Public Suffix List.
+func resolveZone(api *cloudflare.API, host *domain) (string, error) {
+	if zoneIDMatcher.MatchString(host.zone) {
+		return host.zone, nil
+	}
+	zone, err := hostZone(host)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := apiContext()
+	defer cancel()
+
+	res, err := api.ListZonesContext(ctx, cloudflare.WithZoneFilters(zone, api.AccountID, ""))
+	if err != nil {
+		return "", err
+	}
+	switch len(res.Result) {
+	case 0:
+		return "", errors.New("zone could not be found")
+	case 1:
+		return res.Result[0].ID, nil
+	default:
+		return "", fmt.Errorf("ambiguous zone name: %s", zone)
+	}
+}
+
+// findRecords lists the DNS records of a name with the given type (or all
+// types if empty). The results are filtered to exact name matches, so wildcard
+// domains are never confused with the records they would cover.
+func findRecords(api *cloudflare.API, zone string, name string, kind string) ([]cloudflare.DNSRecord, error) {
+	recs, err := listRecords(api, zone, cloudflare.ListDNSRecordsParams{Name: name, Type: kind})
+	if err != nil {
+		return nil, err
+	}
+	var matches []cloudflare.DNSRecord
+	for _, rec := range recs {
+		if strings.EqualFold(rec.Name, name) {
+			matches = append(matches, rec)
+		}
+	}
+	return matches, nil
+}
+
+const (
+	// listPageSize is the number of items to request per page from list endpoints.
+	listPageSize = 100
+
+	// listPageLimit is the maximum number of pages to retrieve from list endpoints,
+	// guarding against a misbehaving API never reporting the last page.
+	listPageLimit = 100
+)
+
+// listRecords retrieves every DNS record of a zone matching the given filters,
+// requesting the pages one by one (each under its own deadline) until the last
+// one reported by the API.
+func listRecords(api *cloudflare.API, zone string, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, error) {
+	var recs []cloudflare.DNSRecord
+	for page := 1; ; page++ {
+		if page > listPageLimit {
+			return nil, fmt.Errorf("too many dns record pages: more than %d", listPageLimit)
+		}
+		params.Page, params.PerPage = page, listPageSize
+
+		ctx, cancel := apiContext()
+		batch, info, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zone), params)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, batch...)
+		if page >= info.TotalPages {
+			return recs, nil
+		}
+	}
+}
+
+// pickRecord selects the record to update out of all the existing records of a
+// domain, according to the configured policy for multiple records as applied by
+// pickValue, the oldest records coming first. Besides the record to update (if
+// any), the superfluous ones to delete are also returned.
+func pickRecord(recs []cloudflare.DNSRecord, address string, previous string) (cloudflare.DNSRecord, bool, []cloudflare.DNSRecord, error) {
+	if len(recs) == 0 {
+		return cloudflare.DNSRecord{}, false, nil, nil
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].CreatedOn.Before(recs[j].CreatedOn) })
+
+	contents := make([]string, len(recs))
+	for i, rec := range recs {
+		contents[i] = rec.Content
+	}
+	index, dups, err := pickValue(contents, address, previous)
+	if err != nil {
+		return cloudflare.DNSRecord{}, false, nil, err
+	}
+	if index < 0 {
+		return cloudflare.DNSRecord{}, false, nil, nil
+	}
+	var stale []cloudflare.DNSRecord
+	for _, i := range dups {
+		stale = append(stale, recs[i])
+	}
+	return recs[index], true, stale, nil
+}
+
+// recordUpdate is the payload of a DNS record update. It is assembled locally
+// instead of reusing the client's type to send exactly the settings maintained by
+// the updater, and to always send the proxy status, even when turned off.
+type recordUpdate struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Content string   `json:"content"`
+	TTL     int      `json:"ttl"`
+	Proxied bool     `json:"proxied"`
+	Comment string   `json:"comment,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// proxied reports whether a CloudFlare DNS record is proxied, records without
+// the field set being served directly.
+func proxied(rec cloudflare.DNSRecord) bool {
+	return rec.Proxied != nil && *rec.Proxied
+}
+
+// recordComment expands the configured comment template of updated records,
+// marking them as machine managed in the CloudFlare dashboard.
+func recordComment(address string) string {
+	if *commentFlag == "" {
+		return ""
+	}
+	host, _ := os.Hostname()
+	return strings.NewReplacer(
+		"{time}", time.Now().UTC().Format("2006-01-02T15:04Z"),
+		"{host}", host,
+		"{address}", address,
+	).Replace(*commentFlag)
+}
+
+// updateDNS updates a single CloudFlare DNS entry of the given type (A or AAAA)
+// to the given IP address, enforcing any configured record settings too. The
+// zone and record IDs are cached in the domain after the first lookup, and are
+// dropped if the update fails in case they went stale. The returned flag reports
+// whether anything needed changing at all.
+func updateDNS(api *cloudflare.API, address string, previous string, host *domain, kind string, ttl int) (bool, error) {
+	// Resolve the zone and record id for the host, unless already known
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = resolveZone(api, host); err != nil {
+			return false, fmt.Errorf("zone id resolution failed: %v", err)
+		}
+	}
+	zone := host.zoneID
+
+	var stale []cloudflare.DNSRecord
+
+	record, known := host.records[kind]
+	if !known {
+		recs, err := findRecords(api, zone, host.name, kind)
+		if err != nil {
+			return false, fmt.Errorf("record id resolution failed: %v", err)
+		}
+		if len(recs) == 0 && !*createFlag {
+			return false, fmt.Errorf("invalid number of DNS records found: %+v", recs)
+		}
+		if record, known, stale, err = pickRecord(recs, address, previous); err != nil {
+			return false, err
+		}
+	}
+	// Refuse to touch records owned by others, if ownership tracking is enabled
+	if *ownerFlag != "" && !host.owned {
+		if err := claimOwnership(api, zone, host, known); err != nil {
+			return false, err
+		}
+		host.owned = true
+	}
+	// Assemble the new record, keeping the proxy status unless overridden
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	update := recordUpdate{
+		Type:    kind,
+		Name:    host.name,
+		Content: address,
+		TTL:     ttl,
+		Comment: recordComment(address),
+		Tags:    splitList(*tagsFlag),
+	}
+	if known {
+		update.Type, update.Name, update.Proxied = record.Type, record.Name, proxied(record)
+	}
+	if host.proxied != nil {
+		update.Proxied = *host.proxied
+	}
+	if update.Proxied {
+		update.TTL = 1 // Proxied records are always automatic TTL
+	}
+	// Skip the update if CloudFlare already has the requested record (e.g. after
+	// a restart, when the previously published address is not known), unless
+	// there are superfluous records to delete still
+	current := known && record.Content == address && record.TTL == update.TTL && proxied(record) == update.Proxied
+	if current && len(stale) == 0 {
+		return false, nil
+	}
+	// Create the record if it's missing, or post the Cloudflare dns update if
+	// it's not up to date already
+	var res []byte
+	switch {
+	case !known:
+		if res, err = callAPI(api, "POST", "/zones/"+zone+"/dns_records", update); err != nil {
+			return false, fmt.Errorf("dns record creation failed: %v", err)
+		}
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+	case !current:
+		if res, err = callAPI(api, "PATCH", "/zones/"+zone+"/dns_records/"+record.ID, update); err != nil {
+			host.zoneID, host.owned = "", false
+			delete(host.records, kind)
+			return false, fmt.Errorf("dns record update failed: %v", err)
+		}
+	}
+	// Delete the records made superfluous by the multiple records policy
+	for _, rec := range stale {
+		if _, err := callAPI(api, "DELETE", "/zones/"+zone+"/dns_records/"+rec.ID, nil); err != nil {
+			logErrorf("Failed to delete superfluous record of %s (%s): %v", host, rec.Content, err)
+			continue
+		}
+		logInfof("Deleted superfluous record of %s (%s)", host, rec.Content)
+	}
+	// Cache the resulting record for the next update
+	if !current {
+		if err := json.Unmarshal(res, &record); err != nil || record.ID == "" {
+			delete(host.records, kind)
+			return true, nil
+		}
+	}
+	if host.records == nil {
+		host.records = make(map[string]cloudflare.DNSRecord)
+	}
+	host.records[kind] = record
+
+	// If requested, ensure the record really changed as requested
+	if *readbackFlag && !*dryRunFlag && !current {
+		if err := readbackDNS(api, zone, record.ID, update); err != nil {
+			delete(host.records, kind)
+			if err := runAlert(*alertFlag, host.name, err.Error()); err != nil {
+				logErrorf("Failed to run alert command: %v", err)
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// readbackDNS re-fetches a freshly updated DNS record and checks that its content,
+// TTL and proxy status match what was requested, catching any update that the
+// API accepted but did not apply.
+func readbackDNS(api *cloudflare.API, zone string, id string, want recordUpdate) error {
+	ctx, cancel := apiContext()
+	defer cancel()
+
+	have, err := api.GetDNSRecord(ctx, cloudflare.ZoneIdentifier(zone), id)
+	if err != nil {
+		return fmt.Errorf("dns record read back failed: %v", err)
+	}
+	var mismatches []string
+	if !net.ParseIP(have.Content).Equal(net.ParseIP(want.Content)) {
+		mismatches = append(mismatches, fmt.Sprintf("content %s != %s", have.Content, want.Content))
+	}
+	if have.TTL != want.TTL {
+		mismatches = append(mismatches, fmt.Sprintf("ttl %d != %d", have.TTL, want.TTL))
+	}
+	if proxied(have) != want.Proxied {
+		mismatches = append(mismatches, fmt.Sprintf("proxied %v != %v", proxied(have), want.Proxied))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("dns record not updated as requested: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
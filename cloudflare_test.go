@@ -0,0 +1,213 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Tests that the policy for multiple records is applied to CloudFlare records,
+// which are picked by their age.
+func TestPickRecord(t *testing.T) {
+	defer func(policy string) { *multiFlag = policy }(*multiFlag)
+
+	// Records are given as id=content pairs, created in reverse order of listing
+	tests := []struct {
+		policy   string
+		records  []string
+		previous string
+		want     string
+		stale    []string
+		fail     bool
+	}{
+		{policy: "fail", records: nil, want: ""},
+		{policy: "fail", records: []string{"a=1.1.1.1"}, want: "a"},
+		{policy: "fail", records: []string{"a=1.1.1.1", "b=2.2.2.2"}, fail: true},
+		{policy: "one", records: []string{"a=1.1.1.1", "b=2.2.2.2"}, want: "b"},
+		{policy: "replace", records: []string{"a=1.1.1.1", "b=2.2.2.2", "c=3.3.3.3"}, want: "c", stale: []string{"b", "a"}},
+		{policy: "converge", records: []string{"a=1.1.1.1", "b=2.2.2.2"}, want: ""},
+		{policy: "converge", records: []string{"a=1.1.1.1", "b=2.2.2.2"}, previous: "1.1.1.1", want: "a"},
+		{policy: "converge", records: []string{"a=1.1.1.1", "b=9.9.9.9"}, previous: "1.1.1.1", want: "b", stale: []string{"a"}},
+		{policy: "converge", records: []string{"a=9.9.9.9", "b=2.2.2.2", "c=9.9.9.9"}, want: "c", stale: []string{"a"}},
+	}
+	for i, tt := range tests {
+		*multiFlag = tt.policy
+
+		var (
+			recs []cloudflare.DNSRecord
+			now  = time.Now()
+		)
+		for j, record := range tt.records {
+			parts := strings.SplitN(record, "=", 2)
+			recs = append(recs, cloudflare.DNSRecord{ID: parts[0], Content: parts[1], CreatedOn: now.Add(-time.Duration(j) * time.Hour)})
+		}
+		rec, found, stale, err := pickRecord(recs, "9.9.9.9", tt.previous)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure, got %v", i, rec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to pick record: %v", i, err)
+			continue
+		}
+		if found != (tt.want != "") || rec.ID != tt.want {
+			t.Errorf("test %d (%s %v, previous %q): record mismatch: have %q/%v, want %q", i, tt.policy, tt.records, tt.previous, rec.ID, found, tt.want)
+		}
+		var ids []string
+		for _, rec := range stale {
+			ids = append(ids, rec.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.stale, ",") {
+			t.Errorf("test %d (%s %v, previous %q): stale mismatch: have %v, want %v", i, tt.policy, tt.records, tt.previous, ids, tt.stale)
+		}
+	}
+}
+
+// newTestAPI creates a CloudFlare client talking to a local mock of the API.
+func newTestAPI(t *testing.T, handler http.Handler) *cloudflare.API {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	api, err := cloudflare.NewWithAPIToken("token", cloudflare.BaseURL(srv.URL), cloudflare.UsingRateLimit(1000), cloudflare.UsingRetryPolicy(0, 0, 0))
+	if err != nil {
+		t.Fatalf("failed to create API client: %v", err)
+	}
+	return api
+}
+
+// replyAPI writes a successful CloudFlare API reply with the given result and
+// pagination info.
+func replyAPI(w http.ResponseWriter, result interface{}, info cloudflare.ResultInfo) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"errors":      []interface{}{},
+		"messages":    []interface{}{},
+		"result":      result,
+		"result_info": info,
+	})
+}
+
+// Tests that DNS records are listed page by page until the last page reported
+// by the API, regardless of the size of the pages, and that the listing gives up
+// on an API reporting an unreasonable number of pages.
+func TestListRecords(t *testing.T) {
+	tests := []struct {
+		pages int
+		want  int
+		fail  bool
+	}{
+		{pages: 0, want: 0},
+		{pages: 1, want: 1},
+		{pages: 3, want: 3},
+		{pages: listPageLimit, want: listPageLimit},
+		{pages: listPageLimit + 1, fail: true},
+	}
+	for i, tt := range tests {
+		var requests int
+		api := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if r.URL.Query().Get("type") != "A" {
+				t.Errorf("test %d: filter mismatch: have %q, want %q", i, r.URL.Query().Get("type"), "A")
+			}
+			var recs []cloudflare.DNSRecord
+			if tt.pages > 0 {
+				recs = append(recs, cloudflare.DNSRecord{ID: fmt.Sprintf("record-%d", page), Type: "A"})
+			}
+			replyAPI(w, recs, cloudflare.ResultInfo{Page: page, PerPage: listPageSize, TotalPages: tt.pages})
+		}))
+		recs, err := listRecords(api, "zone", cloudflare.ListDNSRecordsParams{Type: "A"})
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure, got %d records", i, len(recs))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to list records: %v", i, err)
+			continue
+		}
+		if len(recs) != tt.want {
+			t.Errorf("test %d: record count mismatch: have %d, want %d", i, len(recs), tt.want)
+		}
+		want := tt.pages
+		if want == 0 {
+			want = 1
+		}
+		if requests != want {
+			t.Errorf("test %d: request count mismatch: have %d, want %d", i, requests, want)
+		}
+	}
+}
+
+// Tests that superfluous records are deleted even if the record being kept is
+// already up to date, without rewriting the latter.
+func TestUpdateDNSStale(t *testing.T) {
+	defer func(policy string) { *multiFlag = policy }(*multiFlag)
+
+	// Records are given as id=content pairs, created in order of listing
+	tests := []struct {
+		policy  string
+		records []string
+		changed bool
+		deleted []string
+	}{
+		{policy: "replace", records: []string{"a=9.9.9.9"}, changed: false},
+		{policy: "replace", records: []string{"a=9.9.9.9", "b=2.2.2.2"}, changed: true, deleted: []string{"b"}},
+		{policy: "converge", records: []string{"a=9.9.9.9", "b=9.9.9.9"}, changed: true, deleted: []string{"b"}},
+		{policy: "converge", records: []string{"a=2.2.2.2", "b=9.9.9.9"}, changed: false},
+	}
+	for i, tt := range tests {
+		*multiFlag = tt.policy
+
+		var (
+			recs    []cloudflare.DNSRecord
+			now     = time.Now()
+			deleted []string
+		)
+		for j, record := range tt.records {
+			parts := strings.SplitN(record, "=", 2)
+			recs = append(recs, cloudflare.DNSRecord{ID: parts[0], Type: "A", Name: "www.example.com", Content: parts[1], TTL: 300, CreatedOn: now.Add(time.Duration(j) * time.Hour)})
+		}
+		api := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "GET":
+				replyAPI(w, recs, cloudflare.ResultInfo{Page: 1, PerPage: listPageSize, TotalPages: 1})
+			case "DELETE":
+				deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/zones/0123456789abcdef0123456789abcdef/dns_records/"))
+				replyAPI(w, map[string]string{}, cloudflare.ResultInfo{})
+			default:
+				t.Errorf("test %d: unexpected request: %s %s", i, r.Method, r.URL)
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+			}
+		}))
+		host := &domain{name: "www.example.com", zone: "0123456789abcdef0123456789abcdef"}
+
+		changed, err := updateDNS(api, "9.9.9.9", "", host, "A", 300)
+		if err != nil {
+			t.Errorf("test %d: failed to update record: %v", i, err)
+			continue
+		}
+		if changed != tt.changed {
+			t.Errorf("test %d (%s %v): change mismatch: have %v, want %v", i, tt.policy, tt.records, changed, tt.changed)
+		}
+		if strings.Join(deleted, ",") != strings.Join(tt.deleted, ",") {
+			t.Errorf("test %d (%s %v): deletion mismatch: have %v, want %v", i, tt.policy, tt.records, deleted, tt.deleted)
+		}
+	}
+}
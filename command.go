@@ -0,0 +1,75 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// queryCommand retrieves the external IP address of the machine by running an
+// arbitrary user supplied executable and taking its standard output as the
+// address. The command is split on whitespace into the executable and its
+// arguments; the requested address family is passed to it via the CF_DDNS_FAMILY
+// environment variable (ipv4 or ipv6).
+func queryCommand(command string, ipv6 bool) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", errors.New("no command configured, use -resolve-cmd")
+	}
+	ctx, cancel := context.WithTimeout(aborting, *deadlineFlag)
+	defer cancel()
+
+	family := "ipv4"
+	if ipv6 {
+		family = "ipv6"
+	}
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "CF_DDNS_FAMILY="+family)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// runAlert runs the user supplied alert command (if any) to report a problem
+// with a domain. The command is split on whitespace like the resolution one;
+// the domain and the problem description are passed to it via the CF_DDNS_DOMAIN
+// and CF_DDNS_ALERT environment variables.
+func runAlert(command string, domain string, alert string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(aborting, *deadlineFlag)
+	defer cancel()
+
+	var output bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "CF_DDNS_DOMAIN="+domain, "CF_DDNS_ALERT="+alert)
+	cmd.Stdout, cmd.Stderr = &output, &output
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,916 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// envPrefix is the prefix of the environment variables configuring the flags
+// (e.g. CF_DDNS_TOKEN for -token).
+const envPrefix = "CF_DDNS_"
+
+// flagSources tracks where the values of the flags not left at their defaults
+// came from, as reported by the config show command.
+var flagSources = make(map[string]string)
+
+// explicitFlags returns the names of the flags set on the command line.
+func explicitFlags() map[string]bool {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name], flagSources[f.Name] = true, "command line"
+	})
+	return explicit
+}
+
+// dockerSecrets is the directory Docker (swarm or compose) mounts secrets into.
+const dockerSecrets = "/run/secrets"
+
+// dockerTokenSecret is the name of the Docker secret holding the CloudFlare API
+// token, picked up automatically if no credentials were configured otherwise.
+const dockerTokenSecret = "cf_ddns_token"
+
+// loadEnvironment applies the CF_DDNS_* environment variables to all the flags
+// not set already, marking the ones it set. Variable names are the uppercase flag
+// names with dashes replaced by underscores (e.g. CF_DDNS_API_TIMEOUT), and
+// repeatable flags take one value per line. Following the Docker secrets
+// convention, any variable suffixed with _FILE (e.g. CF_DDNS_DO_TOKEN_FILE) sets
+// the flag to the contents of the referenced file instead.
+func loadEnvironment(explicit map[string]bool) error {
+	for _, env := range os.Environ() {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], envPrefix) {
+			continue
+		}
+		name := strings.ToLower(strings.Replace(strings.TrimPrefix(kv[0], envPrefix), "_", "-", -1))
+		f, source := flag.Lookup(name), "env "+kv[0]
+		if f == nil && strings.HasSuffix(name, "-file") {
+			// No dedicated file flag exists, load the secret from the file
+			if name = strings.TrimSuffix(name, "-file"); flag.Lookup(name) != nil {
+				blob, err := ioutil.ReadFile(kv[1])
+				if err != nil {
+					return fmt.Errorf("invalid %s: %v", kv[0], err)
+				}
+				f, kv[1] = flag.Lookup(name), strings.TrimSpace(string(blob))
+			}
+		}
+		if f == nil {
+			logWarnf("Ignoring unknown environment setting: %s", kv[0])
+			continue
+		}
+		if explicit[name] {
+			continue // Command line flags take precedence
+		}
+		values := []string{kv[1]}
+		if _, repeatable := f.Value.(*listFlag); repeatable {
+			values = splitLines(kv[1])
+		}
+		for _, value := range values {
+			if err := f.Value.Set(value); err != nil {
+				return fmt.Errorf("invalid %s: %v", kv[0], err)
+			}
+		}
+		explicit[name], flagSources[name] = true, source
+	}
+	return nil
+}
+
+// loadDockerSecrets falls back to the CloudFlare API token mounted as a Docker
+// secret if no credentials were configured at all (not even in the config file),
+// so the container works with swarm and compose secrets out of the box. As it is
+// loaded last, the flag is not marked explicit, so a token configured in a later
+// config reload takes its place.
+func loadDockerSecrets() {
+	for _, name := range []string{"token", "token-file", "token-keyring", "vault-path", "aws-secret", "key", "key-file"} {
+		if flag.Lookup(name).Value.String() != "" {
+			return
+		}
+	}
+	path := filepath.Join(dockerSecrets, dockerTokenSecret)
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	*tokenFileFlag = path
+	flagSources["token-file"] = "docker secret " + dockerTokenSecret
+}
+
+// loadSystemdCredentials applies the credentials passed by systemd (via the
+// LoadCredential= or SetCredentialEncrypted= unit settings) to all the flags not
+// set already, marking the ones it set. Credentials are matched to flags by name
+// (e.g. LoadCredential=token:/etc/cloudflare/token), with the CloudFlare token
+// and key loaded as files so they are never copied into the environment.
+func loadSystemdCredentials(explicit map[string]bool) error {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list credentials: %v", err)
+	}
+	for _, file := range files {
+		name, path := file.Name(), filepath.Join(dir, file.Name())
+		if flag.Lookup(name) == nil || file.IsDir() {
+			logWarnf("Ignoring unknown systemd credential: %s", name)
+			continue
+		}
+		if explicit[name] {
+			continue // Command line and environment settings take precedence
+		}
+		switch name {
+		case "token", "key":
+			if explicit[name+"-file"] {
+				continue
+			}
+			if err := flag.Set(name+"-file", path); err != nil {
+				return err
+			}
+			explicit[name+"-file"], flagSources[name+"-file"] = true, "systemd credential "+name
+		default:
+			blob, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read credential %s: %v", name, err)
+			}
+			if err := flag.Set(name, strings.TrimSpace(string(blob))); err != nil {
+				return fmt.Errorf("invalid credential %s: %v", name, err)
+			}
+			flagSources[name] = "systemd credential " + name
+		}
+		explicit[name] = true
+	}
+	return nil
+}
+
+// splitLines splits a multi-line value into its trimmed, non-empty lines.
+func splitLines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// loadConfig reads a TOML configuration file and applies its settings to all the
+// flags not set already (via the command line or the environment). Top level keys
+// are flag names (dashes or underscores alike), list values are joined with commas
+// or set one by one for repeatable flags, and [[domain]] tables describe the domains
+// to update with their own settings, appended to -domains.
+func loadConfig(path string, explicit map[string]bool) error {
+	var (
+		files   []string
+		origins = make(map[string]string)
+	)
+	tree, err := loadConfigTree(path, &files, origins, nil)
+	if err != nil {
+		return err
+	}
+	configFilesLock.Lock()
+	configFiles = files
+	configFilesLock.Unlock()
+
+	if tree, err = applyProfile(tree, *profileFlag, origins); err != nil {
+		return fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	// Apply the settings in a stable order, so errors are deterministic
+	keys := make([]string, 0, len(tree))
+	for key := range tree {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var (
+		domains []string                // Domains updated via the default route
+		uplinks = map[string][]string{} // Domains updated via dedicated uplinks
+		ifaces  []string                // Dedicated uplinks in order of appearance
+	)
+	for _, key := range keys {
+		if key == "domain" {
+			tables, ok := tree[key].([]map[string]interface{})
+			if !ok {
+				return errors.New("domains must be given as [[domain]] tables")
+			}
+			for i, table := range tables {
+				spec, iface, err := configDomain(table)
+				if err != nil {
+					return fmt.Errorf("invalid domain #%d: %v", i+1, err)
+				}
+				if iface == "" {
+					domains = append(domains, spec)
+					continue
+				}
+				if _, ok := uplinks[iface]; !ok {
+					ifaces = append(ifaces, iface)
+				}
+				uplinks[iface] = append(uplinks[iface], spec)
+			}
+			continue
+		}
+		name := strings.Replace(key, "_", "-", -1)
+		if name == "config" {
+			return errors.New("config files cannot be nested, use include")
+		}
+		f := flag.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown setting: %s", key)
+		}
+		if explicit[name] {
+			continue // Command line and environment settings take precedence
+		}
+		values, err := configValues(tree[key])
+		if err != nil {
+			return fmt.Errorf("invalid setting %s: %v", key, err)
+		}
+		if _, repeatable := f.Value.(*listFlag); !repeatable {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err := f.Value.Set(value); err != nil {
+				return fmt.Errorf("invalid setting %s: %v", key, err)
+			}
+		}
+		flagSources[name] = "config " + origins[key]
+	}
+	// Append the configured domains to any given on the command line
+	if len(domains) > 0 {
+		if *domainsFlag != "" {
+			domains = append([]string{*domainsFlag}, domains...)
+		}
+		*domainsFlag = strings.Join(domains, ",")
+		addSource("domains", "config "+origins["domain"])
+	}
+	for _, iface := range ifaces {
+		uplinkFlags.Set(iface + "=" + strings.Join(uplinks[iface], ","))
+		addSource("uplink", "config "+origins["domain"])
+	}
+	return nil
+}
+
+// loadConfigTree reads and parses a configuration file along with all the files
+// it includes, merging them into a single tree. Included files are merged in the
+// order listed (glob patterns in name order), with the including file merged on
+// top. The loaded files are collected along with the files each setting came
+// from, and the stack is used to detect cycles.
+func loadConfigTree(path string, files *[]string, origins map[string]string, stack []string) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range stack {
+		if parent == abs {
+			return nil, fmt.Errorf("config %s includes itself", path)
+		}
+	}
+	blob, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	*files = append(*files, path)
+
+	tree, err := parseTOML(string(blob))
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	includes, ok := tree["include"]
+	if !ok {
+		noteOrigins(origins, tree, path)
+		return tree, nil
+	}
+	delete(tree, "include")
+
+	patterns, err := configValues(includes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: invalid include: %v", path, err)
+	}
+	merged := make(map[string]interface{})
+	for _, pattern := range patterns {
+		// Relative includes are relative to the including file, not the cwd
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config %s: invalid include %s: %v", path, pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("invalid config %s: included %s not found", path, pattern)
+		}
+		for _, match := range matches {
+			fragment, err := loadConfigTree(match, files, origins, append(stack, abs))
+			if err != nil {
+				return nil, err
+			}
+			mergeConfig(merged, fragment)
+		}
+	}
+	mergeConfig(merged, tree)
+	noteOrigins(origins, tree, path)
+	return merged, nil
+}
+
+// noteOrigins records the file the settings of a configuration tree came from.
+// Merged tables and arrays of tables list all the files contributing to them.
+func noteOrigins(origins map[string]string, tree map[string]interface{}, path string) {
+	for key, value := range tree {
+		switch value.(type) {
+		case map[string]interface{}, []map[string]interface{}:
+			if prev, ok := origins[key]; ok && !contains(strings.Split(prev, ", "), path) {
+				origins[key] = prev + ", " + path
+				continue
+			}
+		}
+		origins[key] = path
+	}
+}
+
+// addSource records an additional source a flag's value was assembled from.
+func addSource(name string, source string) {
+	if prev, ok := flagSources[name]; ok && prev != source {
+		flagSources[name] = prev + ", " + source
+		return
+	}
+	flagSources[name] = source
+}
+
+// mergeConfig merges a configuration tree into another one: tables are merged
+// recursively, arrays of tables (e.g. [[domain]]) are concatenated, and any other
+// setting replaces the one already present. Tables and arrays are copied over,
+// so merging further trees never modifies the source ones.
+func mergeConfig(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if table, ok := dst[key].(map[string]interface{}); ok {
+				mergeConfig(table, value)
+				continue
+			}
+		case []map[string]interface{}:
+			if tables, ok := dst[key].([]map[string]interface{}); ok {
+				dst[key] = append(tables, copyConfig(value).([]map[string]interface{})...)
+				continue
+			}
+		}
+		dst[key] = copyConfig(value)
+	}
+}
+
+// copyConfig deep copies a configuration value, duplicating any tables and arrays.
+func copyConfig(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		table := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			table[key] = copyConfig(item)
+		}
+		return table
+	case []map[string]interface{}:
+		tables := make([]map[string]interface{}, len(value))
+		for i, item := range value {
+			tables[i] = copyConfig(item).(map[string]interface{})
+		}
+		return tables
+	case []interface{}:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = copyConfig(item)
+		}
+		return items
+	default:
+		return value
+	}
+}
+
+// applyProfile overlays the settings of the selected [profile.<name>] table onto
+// the shared top level ones, with the settings of the profile replacing the shared
+// ones and its [[profile.<name>.domain]] tables added to the shared domains. The
+// tables of the other profiles are dropped.
+func applyProfile(tree map[string]interface{}, name string, origins map[string]string) (map[string]interface{}, error) {
+	var profiles map[string]interface{}
+	if existing, ok := tree["profile"]; ok {
+		if profiles, ok = existing.(map[string]interface{}); !ok {
+			return nil, errors.New("profiles must be given as [profile.<name>] tables")
+		}
+		delete(tree, "profile")
+	}
+	if name == "" {
+		return tree, nil
+	}
+	profile, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unknown profile: %s", name)
+	}
+	for key, value := range profile {
+		switch key {
+		case "profile":
+			return nil, errors.New("profiles cannot be nested")
+
+		case "domain":
+			tables, ok := value.([]map[string]interface{})
+			if !ok {
+				return nil, errors.New("domains must be given as [[profile.<name>.domain]] tables")
+			}
+			shared, ok := tree["domain"].([]map[string]interface{})
+			if !ok && tree["domain"] != nil {
+				return nil, errors.New("domains must be given as [[domain]] tables")
+			}
+			tree["domain"] = append(shared, tables...)
+			if origins["domain"] != "" {
+				origins["domain"] += ", "
+			}
+			origins["domain"] += origins["profile"] + " [profile." + name + "]"
+
+		default:
+			tree[key] = value
+			origins[key] = origins["profile"] + " [profile." + name + "]"
+		}
+	}
+	return tree, nil
+}
+
+// snapshotFlags captures the current values of all the flags and their sources,
+// returning a function to restore them (e.g. if reloading a broken config file had
+// to be aborted).
+func snapshotFlags() func() {
+	var (
+		values  = make(map[string]string)
+		lists   = make(map[string]listFlag)
+		sources = make(map[string]string)
+	)
+	for name, source := range flagSources {
+		sources[name] = source
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if list, ok := f.Value.(*listFlag); ok {
+			lists[f.Name] = append(listFlag{}, *list...)
+			return
+		}
+		values[f.Name] = f.Value.String()
+	})
+	return func() {
+		flag.VisitAll(func(f *flag.Flag) {
+			if list, ok := f.Value.(*listFlag); ok {
+				*list = lists[f.Name]
+				return
+			}
+			f.Value.Set(values[f.Name])
+		})
+		flagSources = make(map[string]string)
+		for name, source := range sources {
+			flagSources[name] = source
+		}
+	}
+}
+
+// configFiles are the configuration files loaded last (the main one and all the
+// ones it includes), watched for changes.
+var (
+	configFiles     []string
+	configFilesLock sync.Mutex
+)
+
+// watchConfig notifies on the given hangup signals or whenever the modification
+// time of the config file or any of its includes changes, checked at the same
+// interval as the credential files.
+func watchConfig(path string, signals <-chan os.Signal) <-chan struct{} {
+	reloads := make(chan struct{}, 1)
+
+	// modified summarizes the modification times of all the loaded files, empty
+	// if the main one is missing (e.g. while being replaced)
+	modified := func() string {
+		if _, err := os.Stat(path); err != nil {
+			return ""
+		}
+		configFilesLock.Lock()
+		files := configFiles
+		configFilesLock.Unlock()
+
+		var times []string
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				times = append(times, file+"@"+info.ModTime().String())
+			}
+		}
+		return strings.Join(times, ",")
+	}
+	go func() {
+		ticker := time.NewTicker(credentialsCheck)
+		defer ticker.Stop()
+
+		last := modified()
+		for {
+			select {
+			case <-ticker.C:
+				current := modified()
+				if current == "" || current == last {
+					continue
+				}
+				last = current
+
+			case <-signals:
+				last = modified()
+			}
+			select {
+			case reloads <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return reloads
+}
+
+// configDomain converts a [[domain]] table of the config file into the textual
+// domain specification accepted by -domains, along with the network interface
+// of the uplink to resolve its address through (empty for the default route).
+func configDomain(table map[string]interface{}) (string, string, error) {
+	name, ok := table["name"].(string)
+	if !ok || name == "" {
+		return "", "", errors.New("missing domain name")
+	}
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		if key != "name" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var (
+		spec  = name
+		iface string
+	)
+	for _, key := range keys {
+		values, err := configValues(table[key])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid %s of %s: %v", key, name, err)
+		}
+		switch key {
+		case "provider", "types":
+			spec += ":" + key + "=" + strings.Join(values, "+")
+		case "zone", "ttl", "proxied", "uplink":
+			if len(values) != 1 {
+				return "", "", fmt.Errorf("invalid %s of %s: single value expected", key, name)
+			}
+			if key == "uplink" {
+				iface = values[0]
+				continue
+			}
+			spec += ":" + key + "=" + values[0]
+		default:
+			return "", "", fmt.Errorf("unknown setting of %s: %s", name, key)
+		}
+	}
+	if strings.ContainsAny(strings.TrimPrefix(spec, name), ",") || strings.ContainsAny(iface, "=,") {
+		return "", "", fmt.Errorf("invalid settings of %s: commas not allowed", name)
+	}
+	return spec, iface, nil
+}
+
+// configValues converts a parsed config value into its textual form(s), flattening
+// arrays into one string per item.
+func configValues(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case string:
+		return []string{value}, nil
+	case int64:
+		return []string{strconv.FormatInt(value, 10)}, nil
+	case bool:
+		return []string{strconv.FormatBool(value)}, nil
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if _, nested := item.([]interface{}); nested {
+				return nil, errors.New("nested arrays not supported")
+			}
+			items, err := configValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, items...)
+		}
+		return values, nil
+	default:
+		return nil, errors.New("tables not supported here")
+	}
+}
+
+// parseTOML parses the subset of TOML needed for configuration files: comments,
+// key/value pairs of strings, integers, booleans and (multi-line) arrays thereof,
+// [tables] and [[arrays of tables]]. Dotted (and quoted) keys and table names
+// create nested tables.
+func parseTOML(data string) (map[string]interface{}, error) {
+	var (
+		root     = make(map[string]interface{})
+		current  = root
+		implicit = make(map[uintptr]bool) // Tables created by dotted names only
+		lines    = strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+	)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		number := i + 1
+
+		// Handle table headers, creating the tables as needed
+		if strings.HasPrefix(line, "[") {
+			array := strings.HasPrefix(line, "[[")
+			if !strings.HasSuffix(line, "]") || (array && !strings.HasSuffix(line, "]]")) {
+				return nil, fmt.Errorf("line %d: invalid table header: %s", number, line)
+			}
+			name := line[1 : len(line)-1]
+			if array {
+				name = line[2 : len(line)-2]
+			}
+			path, rest, err := parseTOMLKey(name)
+			if err != nil || rest != "" {
+				return nil, fmt.Errorf("line %d: invalid table header: %s", number, line)
+			}
+			name = strings.Join(path, ".")
+
+			parent := root
+			for _, part := range path[:len(path)-1] {
+				if parent, err = tomlTable(parent, part, implicit); err != nil {
+					return nil, fmt.Errorf("line %d: %v", number, err)
+				}
+			}
+			last := path[len(path)-1]
+			if array {
+				var tables []map[string]interface{}
+				if existing, ok := parent[last]; ok {
+					if tables, ok = existing.([]map[string]interface{}); !ok {
+						return nil, fmt.Errorf("line %d: %s is not an array of tables", number, name)
+					}
+				}
+				current = make(map[string]interface{})
+				parent[last] = append(tables, current)
+			} else {
+				// Tables implicitly created by a longer name may be defined once
+				if existing, ok := parent[last]; ok {
+					table, ok := existing.(map[string]interface{})
+					if !ok || !implicit[reflect.ValueOf(table).Pointer()] {
+						return nil, fmt.Errorf("line %d: duplicate table %s", number, name)
+					}
+					delete(implicit, reflect.ValueOf(table).Pointer())
+					current = table
+				} else {
+					current = make(map[string]interface{})
+					parent[last] = current
+				}
+			}
+			continue
+		}
+		// Handle key/value pairs, joining multi-line arrays into a single line
+		path, rest, err := parseTOMLKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number, err)
+		}
+		if !strings.HasPrefix(rest, "=") {
+			return nil, fmt.Errorf("line %d: expected key = value: %s", number, line)
+		}
+		table := current
+		for _, part := range path[:len(path)-1] {
+			if table, err = tomlTable(table, part, implicit); err != nil {
+				return nil, fmt.Errorf("line %d: %v", number, err)
+			}
+		}
+		key := path[len(path)-1]
+		if _, ok := table[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %s", number, strings.Join(path, "."))
+		}
+		raw := strings.TrimSpace(rest[1:])
+		for strings.HasPrefix(raw, "[") && !tomlBalanced(raw) && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		value, rest, err := parseTOMLValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("line %d: unexpected trailing data: %s", number, rest)
+		}
+		table[key] = value
+	}
+	return root, nil
+}
+
+// tomlTable returns the named sub-table of a table, creating it if missing (and
+// marking it as implicitly created). For arrays of tables, the last table is
+// returned, as per the TOML semantics.
+func tomlTable(parent map[string]interface{}, name string, implicit map[uintptr]bool) (map[string]interface{}, error) {
+	switch existing := parent[name].(type) {
+	case nil:
+		table := make(map[string]interface{})
+		parent[name] = table
+		implicit[reflect.ValueOf(table).Pointer()] = true
+		return table, nil
+	case map[string]interface{}:
+		return existing, nil
+	case []map[string]interface{}:
+		return existing[len(existing)-1], nil
+	default:
+		return nil, fmt.Errorf("%s is not a table", name)
+	}
+}
+
+// parseTOMLKey parses a (possibly dotted) key from the start of the input, made
+// up of bare parts (letters, digits, dashes and underscores) or quoted strings,
+// returning the parts along with the unconsumed remainder.
+func parseTOMLKey(input string) ([]string, string, error) {
+	var (
+		parts []string
+		rest  = strings.TrimSpace(input)
+	)
+	for {
+		var part string
+		if strings.HasPrefix(rest, "\"") || strings.HasPrefix(rest, "'") {
+			value, remainder, err := parseTOMLValue(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			part, rest = value.(string), remainder
+		} else {
+			end := strings.IndexFunc(rest, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+			})
+			if end < 0 {
+				end = len(rest)
+			}
+			part, rest = rest[:end], rest[end:]
+		}
+		if part == "" {
+			return nil, "", fmt.Errorf("empty key: %s", input)
+		}
+		parts = append(parts, part)
+
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, ".") {
+			return parts, rest, nil
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+}
+
+// parseTOMLValue parses a single TOML value from the start of the input,
+// returning it along with the unconsumed remainder.
+func parseTOMLValue(input string) (interface{}, string, error) {
+	input = strings.TrimSpace(input)
+	switch {
+	case input == "":
+		return nil, "", errors.New("missing value")
+
+	case input[0] == '"':
+		// Basic string, find the closing quote skipping escaped ones
+		for i := 1; i < len(input); i++ {
+			switch input[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(input[:i+1])
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid string %s: %v", input[:i+1], err)
+				}
+				return value, input[i+1:], nil
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string: %s", input)
+
+	case input[0] == '\'':
+		// Literal string, taken as is
+		end := strings.IndexByte(input[1:], '\'')
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string: %s", input)
+		}
+		return input[1 : end+1], input[end+2:], nil
+
+	case input[0] == '[':
+		// Array, parse the items one by one
+		var items []interface{}
+		rest := strings.TrimSpace(input[1:])
+		for {
+			if strings.HasPrefix(rest, "]") {
+				return items, rest[1:], nil
+			}
+			item, remainder, err := parseTOMLValue(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, item)
+
+			rest = strings.TrimSpace(remainder)
+			switch {
+			case strings.HasPrefix(rest, ","):
+				rest = strings.TrimSpace(rest[1:])
+			case strings.HasPrefix(rest, "]"):
+			default:
+				return nil, "", fmt.Errorf("unterminated array: %s", input)
+			}
+		}
+
+	default:
+		// Bare value, up to the next separator
+		end := strings.IndexAny(input, ",] \t")
+		if end < 0 {
+			end = len(input)
+		}
+		token, rest := input[:end], input[end:]
+		switch token {
+		case "true":
+			return true, rest, nil
+		case "false":
+			return false, rest, nil
+		}
+		number, err := parseTOMLInteger(token)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid value: %s", token)
+		}
+		return number, rest, nil
+	}
+}
+
+// parseTOMLInteger parses a TOML integer: a decimal one with an optional sign and
+// no leading zeros, or an unsigned hexadecimal, octal or binary one with a 0x, 0o
+// or 0b prefix. Underscores are allowed between digits.
+func parseTOMLInteger(token string) (int64, error) {
+	base, digits := 10, strings.TrimLeft(token, "+-")
+	switch {
+	case strings.HasPrefix(token, "0x"):
+		base, digits = 16, token[2:]
+	case strings.HasPrefix(token, "0o"):
+		base, digits = 8, token[2:]
+	case strings.HasPrefix(token, "0b"):
+		base, digits = 2, token[2:]
+	default:
+		if len(token)-len(digits) > 1 || (len(digits) > 1 && digits[0] == '0') {
+			return 0, fmt.Errorf("invalid integer: %s", token)
+		}
+	}
+	if digits == "" || strings.HasPrefix(digits, "_") || strings.HasSuffix(digits, "_") || strings.Contains(digits, "__") {
+		return 0, fmt.Errorf("invalid integer: %s", token)
+	}
+	if base != 10 && strings.ContainsAny(digits, "+-") {
+		return 0, fmt.Errorf("invalid integer: %s", token)
+	}
+	if base == 10 {
+		digits = token
+	}
+	return strconv.ParseInt(strings.Replace(digits, "_", "", -1), base, 64)
+}
+
+// stripComment removes a trailing # comment from a line, ignoring any hashes
+// within quoted strings.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlBalanced reports whether all the brackets opened outside of strings in an
+// array value are closed.
+func tomlBalanced(value string) bool {
+	var (
+		depth int
+		quote byte
+	)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && c == '[':
+			depth++
+		case quote == 0 && c == ']':
+			depth--
+		}
+	}
+	return depth <= 0
+}
@@ -0,0 +1,266 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Tests that the supported subset of TOML is parsed correctly.
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "basic strings with escapes",
+			input: `key = "a \"quoted\" \\ tab\t newline\n unicode \u00e9"`,
+			want:  map[string]interface{}{"key": "a \"quoted\" \\ tab\t newline\n unicode é"},
+		},
+		{
+			name:  "literal strings kept as is",
+			input: `key = 'C:\path\no "escapes"'`,
+			want:  map[string]interface{}{"key": `C:\path\no "escapes"`},
+		},
+		{
+			name:  "integers and booleans",
+			input: "a = 42\nb = -7\nc = 1_000\nd = 0x1f\ne = true\nf = false\ng = 0o300\nh = 0b101\ni = +3\nj = 0\nk = -0",
+			want:  map[string]interface{}{"a": int64(42), "b": int64(-7), "c": int64(1000), "d": int64(31), "e": true, "f": false, "g": int64(192), "h": int64(5), "i": int64(3), "j": int64(0), "k": int64(0)},
+		},
+		{
+			name:  "arrays",
+			input: `a = []` + "\n" + `b = ["x", 'y', 3, true]` + "\n" + `c = [[1, 2], ["z"],]`,
+			want: map[string]interface{}{
+				"a": []interface{}(nil),
+				"b": []interface{}{"x", "y", int64(3), true},
+				"c": []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{"z"}},
+			},
+		},
+		{
+			name:  "multi-line arrays with comments",
+			input: "a = [\n  \"x\", # first ]\n  \"y\" # second\n]\nb = 1",
+			want:  map[string]interface{}{"a": []interface{}{"x", "y"}, "b": int64(1)},
+		},
+		{
+			name:  "inline comments",
+			input: "# heading\na = \"x # not a comment\" # comment\nb = 'y # neither' # comment\nc = \"esc \\\" # still string\" # comment\nd = 1 # comment",
+			want:  map[string]interface{}{"a": "x # not a comment", "b": "y # neither", "c": "esc \" # still string", "d": int64(1)},
+		},
+		{
+			name:  "quoted keys",
+			input: `"a=b" = 1` + "\n" + `'c.d' = 2` + "\n" + `"e f".g = 3`,
+			want: map[string]interface{}{
+				"a=b": int64(1),
+				"c.d": int64(2),
+				"e f": map[string]interface{}{"g": int64(3)},
+			},
+		},
+		{
+			name:  "dotted keys",
+			input: "a.b = 1\na.c = 2",
+			want:  map[string]interface{}{"a": map[string]interface{}{"b": int64(1), "c": int64(2)}},
+		},
+		{
+			name:  "tables and arrays of tables",
+			input: "top = 1\n[table]\nkey = 2\n[[array]]\nkey = 3\n[[array]]\nkey = 4\n[nested.\"quoted.name\"]\nkey = 5",
+			want: map[string]interface{}{
+				"top":   int64(1),
+				"table": map[string]interface{}{"key": int64(2)},
+				"array": []map[string]interface{}{{"key": int64(3)}, {"key": int64(4)}},
+				"nested": map[string]interface{}{
+					"quoted.name": map[string]interface{}{"key": int64(5)},
+				},
+			},
+		},
+		{
+			name:  "sub-tables of arrays of tables",
+			input: "[[a]]\n[a.b]\nkey = 1\n[[a]]\n[a.b]\nkey = 2",
+			want: map[string]interface{}{
+				"a": []map[string]interface{}{
+					{"b": map[string]interface{}{"key": int64(1)}},
+					{"b": map[string]interface{}{"key": int64(2)}},
+				},
+			},
+		},
+		{
+			name:  "table defined after its sub-tables",
+			input: "[[profile.x.domain]]\nname = \"www\"\n[profile.x]\nttl = 60",
+			want: map[string]interface{}{
+				"profile": map[string]interface{}{
+					"x": map[string]interface{}{
+						"domain": []map[string]interface{}{{"name": "www"}},
+						"ttl":    int64(60),
+					},
+				},
+			},
+		},
+		{
+			name:  "windows line endings",
+			input: "a = 1\r\n[b]\r\nc = 2\r\n",
+			want:  map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": int64(2)}},
+		},
+	}
+	for _, tt := range tests {
+		tree, err := parseTOML(tt.input)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tree, tt.want) {
+			t.Errorf("%s: tree mismatch: have %#v, want %#v", tt.name, tree, tt.want)
+		}
+	}
+}
+
+// Tests that invalid TOML inputs are rejected.
+func TestParseTOMLFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"duplicate key", "a = 1\na = 2"},
+		{"duplicate dotted key", "a.b = 1\na.b = 2"},
+		{"duplicate table", "[a]\n[a]"},
+		{"table redefined after sub-table", "[a]\n[a.b]\n[a]"},
+		{"table over array of tables", "[[a]]\n[a]"},
+		{"array of tables over table", "[a]\n[[a]]"},
+		{"table over value", "a = 1\n[a]"},
+		{"unterminated header", "[a"},
+		{"unterminated array header", "[[a]"},
+		{"empty header", "[]"},
+		{"invalid header", "[a b]"},
+		{"missing value", "a ="},
+		{"missing equals", "a 1"},
+		{"empty key", "= 1"},
+		{"empty quoted key", `"" = 1`},
+		{"invalid bare key", "a b = 1"},
+		{"unterminated string", `a = "x`},
+		{"unterminated literal string", `a = 'x`},
+		{"invalid escape", `a = "\q"`},
+		{"unterminated array", "a = [1, 2"},
+		{"missing array separator", "a = [1 2]"},
+		{"trailing data", `a = "x" y`},
+		{"invalid bare value", "a = yes"},
+		{"leading zero", "a = 0300"},
+		{"leading zero with sign", "a = -01"},
+		{"uppercase prefix", "a = 0X1F"},
+		{"signed hexadecimal", "a = -0x1f"},
+		{"sign after prefix", "a = 0x-1f"},
+		{"empty prefixed digits", "a = 0b"},
+		{"double sign", "a = +-1"},
+		{"leading underscore", "a = 0x_1f"},
+		{"trailing underscore", "a = 1_"},
+		{"double underscore", "a = 1__000"},
+		{"invalid octal digit", "a = 0o8"},
+	}
+	for _, tt := range tests {
+		if tree, err := parseTOML(tt.input); err == nil {
+			t.Errorf("%s: expected failure, got %v", tt.name, tree)
+		}
+	}
+}
+
+// Tests that comments are stripped outside of strings only.
+func TestStripComment(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"a = 1", "a = 1"},
+		{"a = 1 # comment", "a = 1 "},
+		{"# comment", ""},
+		{`a = "#" # comment`, `a = "#" `},
+		{`a = '#' # comment`, `a = '#' `},
+		{`a = "\"#" # comment`, `a = "\"#" `},
+		{`a = '\' # comment`, `a = '\' `},
+		{`a = "unterminated # string`, `a = "unterminated # string`},
+	}
+	for _, tt := range tests {
+		if have := stripComment(tt.line); have != tt.want {
+			t.Errorf("%q: stripped mismatch: have %q, want %q", tt.line, have, tt.want)
+		}
+	}
+}
+
+// Tests that unclosed multi-line arrays are detected.
+func TestTOMLBalanced(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"[]", true},
+		{"[1, 2]", true},
+		{"[", false},
+		{"[[1], [2]", false},
+		{"[[1], [2]]", true},
+		{`["]"`, false},
+		{`["["]`, true},
+		{`['\', "]"]`, true},
+		{`["\"]"`, false},
+	}
+	for _, tt := range tests {
+		if have := tomlBalanced(tt.value); have != tt.want {
+			t.Errorf("%q: balance mismatch: have %v, want %v", tt.value, have, tt.want)
+		}
+	}
+}
+
+// Tests that restoring a flag snapshot reverts both the values and the recorded
+// sources, so settings dropped from a reloaded config are not reported or kept.
+func TestSnapshotFlags(t *testing.T) {
+	defer func(token string, sources map[string]string) {
+		*tokenFileFlag, flagSources = token, sources
+	}(*tokenFileFlag, flagSources)
+
+	*tokenFileFlag, flagSources = "", map[string]string{"ttl": "command line"}
+	restore := snapshotFlags()
+
+	*tokenFileFlag = "/run/secrets/cf_ddns_token"
+	flagSources["token-file"] = "docker secret cf_ddns_token"
+	flagSources["ttl"] = "config updater.toml"
+
+	restore()
+	if *tokenFileFlag != "" {
+		t.Errorf("token file mismatch: have %q, want %q", *tokenFileFlag, "")
+	}
+	if want := map[string]string{"ttl": "command line"}; !reflect.DeepEqual(flagSources, want) {
+		t.Errorf("sources mismatch: have %v, want %v", flagSources, want)
+	}
+}
+
+// Tests that merging configuration trees never modifies the tables and arrays of
+// the trees merged in earlier.
+func TestMergeConfigCopies(t *testing.T) {
+	first := map[string]interface{}{
+		"table":  map[string]interface{}{"a": int64(1)},
+		"domain": []map[string]interface{}{{"name": "a.example.com"}},
+		"list":   []interface{}{"x"},
+	}
+	second := map[string]interface{}{
+		"table":  map[string]interface{}{"b": int64(2)},
+		"domain": []map[string]interface{}{{"name": "b.example.com"}},
+	}
+	merged := make(map[string]interface{})
+	mergeConfig(merged, first)
+	mergeConfig(merged, second)
+
+	merged["domain"].([]map[string]interface{})[0]["name"] = "c.example.com"
+	merged["list"].([]interface{})[0] = "y"
+
+	want := map[string]interface{}{
+		"table":  map[string]interface{}{"a": int64(1)},
+		"domain": []map[string]interface{}{{"name": "a.example.com"}},
+		"list":   []interface{}{"x"},
+	}
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("source tree mismatch: have %v, want %v", first, want)
+	}
+	if table := merged["table"].(map[string]interface{}); len(table) != 2 {
+		t.Errorf("merged table mismatch: have %v, want both settings", table)
+	}
+}
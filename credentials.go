@@ -0,0 +1,264 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialsCheck is the interval to check credential files for changes.
+const credentialsCheck = time.Minute
+
+// credentials is a set of CloudFlare API credentials: a scoped API token or the
+// legacy email and global API key pair. Secrets may be sourced from files, in
+// which case they can be reloaded while running, allowing rotation without a
+// restart.
+type credentials struct {
+	token string // Scoped API token (preferred)
+	user  string // Account email for the global API key
+	key   string // Legacy global API key
+
+	tokenFile   string                 // File to reload the API token from (empty = static)
+	tokenSource func() (string, error) // Secret store to reload the API token from (nil = static)
+	keyFile     string                 // File to reload the global API key from (empty = static)
+
+	lock sync.RWMutex
+}
+
+// newCredentials assembles a set of CloudFlare API credentials, loading any
+// secrets sourced from files and validating that exactly one authentication
+// method was configured.
+func newCredentials(token string, tokenFile string, user string, key string, keyFile string) (*credentials, error) {
+	c := &credentials{token: token, user: user, key: key, tokenFile: tokenFile, keyFile: keyFile}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	for _, path := range []string{tokenFile, keyFile} {
+		if path != "" {
+			checkSecretFile(path)
+		}
+	}
+	switch {
+	case c.token != "" && c.key != "":
+		return nil, errors.New("both API token and global API key specified")
+	case c.token != "":
+		return c, nil
+	case c.user != "" && c.key != "":
+		return c, nil
+	default:
+		return nil, errors.New("no credentials specified, use -token or -user and -key")
+	}
+}
+
+// newSourcedCredentials creates a set of CloudFlare API credentials with the token
+// loaded from an external secret store (e.g. the OS keyring), reloaded the same
+// way as file sourced ones.
+func newSourcedCredentials(source func() (string, error)) (*credentials, error) {
+	c := &credentials{tokenSource: source}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// tokenStore returns the token source of the configured external secret store,
+// or nil if the token is not sourced from any.
+func tokenStore(client *http.Client) (func() (string, error), error) {
+	var stores []string
+	for _, name := range []string{"token-keyring", "vault-path", "aws-secret"} {
+		if flag.Lookup(name).Value.String() != "" {
+			stores = append(stores, "-"+name)
+		}
+	}
+	if len(stores) > 1 {
+		return nil, fmt.Errorf("multiple secret stores specified: %s", strings.Join(stores, ", "))
+	}
+	switch {
+	case *tokenKeyring != "":
+		return keyringSource(*tokenKeyring), nil
+
+	case *vaultPathFlag != "":
+		secret, err := newVaultSecret(client, *vaultPathFlag, *vaultAuthFlag)
+		if err != nil {
+			return nil, err
+		}
+		return secret.load, nil
+
+	case *awsSecretFlag != "":
+		secret, err := newAWSSecret(client, *awsSecretFlag)
+		if err != nil {
+			return nil, err
+		}
+		return secret.load, nil
+	}
+	return nil, nil
+}
+
+// reload re-reads any secrets sourced from files or secret stores, returning
+// whether anything changed since the last load.
+func (c *credentials) reload() (bool, error) {
+	token, key, err := c.read()
+	if err != nil {
+		return false, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	changed := token != c.token || key != c.key
+	c.token, c.key = token, key
+	return changed, nil
+}
+
+// read loads the current secrets from their files or stores (or memory if static).
+func (c *credentials) read() (string, string, error) {
+	c.lock.RLock()
+	token, key := c.token, c.key
+	c.lock.RUnlock()
+
+	if c.tokenFile != "" {
+		secret, err := readSecretFile(c.tokenFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read API token: %v", err)
+		}
+		if token = secret; token == "" {
+			return "", "", errors.New("empty API token file")
+		}
+	}
+	if c.tokenSource != nil {
+		secret, err := c.tokenSource()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to load API token: %v", err)
+		}
+		if token = strings.TrimSpace(secret); token == "" {
+			return "", "", errors.New("empty API token in secret store")
+		}
+	}
+	if c.keyFile != "" {
+		secret, err := readSecretFile(c.keyFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read API key: %v", err)
+		}
+		if key = secret; key == "" {
+			return "", "", errors.New("empty API key file")
+		}
+	}
+	return token, key, nil
+}
+
+// scoped returns whether the credentials authenticate via a scoped API token.
+func (c *credentials) scoped() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.token != ""
+}
+
+// reloadable returns whether any of the secrets are sourced from files or stores.
+func (c *credentials) reloadable() bool {
+	return c.tokenFile != "" || c.tokenSource != nil || c.keyFile != ""
+}
+
+// authorize sets the authentication headers of an API request.
+func (c *credentials) authorize(req *http.Request) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		return
+	}
+	req.Header.Set("X-Auth-Email", c.user)
+	req.Header.Set("X-Auth-Key", c.key)
+}
+
+// authTransport is an HTTP transport authenticating every request with the
+// current version of a set of credentials.
+type authTransport struct {
+	base  http.RoundTripper // Transport to execute the actual requests with
+	creds *credentials      // Credentials to authenticate the requests with
+}
+
+// RoundTrip implements http.RoundTripper, authenticating a single request. In dry
+// run mode, requests changing anything are not sent, but answered with an empty
+// successful reply instead.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if *dryRunFlag && req.Method != "GET" {
+		var body []byte
+		if req.Body != nil {
+			body, _ = ioutil.ReadAll(req.Body)
+			req.Body.Close()
+		}
+		if simulated(req.Method, req.URL.String(), body) {
+			return &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       ioutil.NopCloser(strings.NewReader(`{"success":true,"errors":[],"messages":[],"result":null}`)),
+				Request:    req,
+			}, nil
+		}
+	}
+	authed := req.Clone(req.Context())
+	t.creds.authorize(authed)
+	return t.base.RoundTrip(authed)
+}
+
+// watchCredentials reloads the file sourced credentials periodically, so rotated
+// secrets are picked up without restarting. The returned function stops watching
+// them (e.g. when replaced on a config reload). SIGHUP is handled by the update
+// loop, reloading them via reloadCredentials.
+func watchCredentials(creds []*credentials) func() {
+	var reloadable bool
+	for _, c := range creds {
+		reloadable = reloadable || c.reloadable()
+	}
+	if !reloadable {
+		return func() {}
+	}
+	quit := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(credentialsCheck)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				reloadCredentials(creds)
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
+// reloadCredentials reloads all the file sourced credentials, reporting the ones
+// that were rotated.
+func reloadCredentials(creds []*credentials) {
+	for _, c := range creds {
+		if !c.reloadable() {
+			continue
+		}
+		changed, err := c.reload()
+		switch {
+		case err != nil:
+			logErrorf("Failed to reload CloudFlare credentials: %v", err)
+		case changed:
+			logInfof("Reloaded rotated CloudFlare credentials")
+		}
+	}
+}
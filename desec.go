@@ -0,0 +1,167 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// desecEndpoint is the base URL of the deSEC API.
+const desecEndpoint = "https://desec.io/api/v1"
+
+// desecProvider is the deSEC DNS provider, updating the record sets of domains
+// through the REST API, authenticated with an API token. deSEC enforces a per
+// domain minimum TTL (usually an hour, lowered for dynDNS domains), which the
+// requested TTLs are raised to.
+type desecProvider struct {
+	client *http.Client // HTTP client to execute the API calls with
+	token  string       // API token of the account
+
+	minTTLs map[string]int // Minimum TTLs of the domains, once retrieved
+	lock    sync.Mutex
+}
+
+// newDesecProvider creates a deSEC DNS provider with the given API token,
+// falling back to the environment if none was specified.
+func newDesecProvider(client *http.Client, token string) (*desecProvider, error) {
+	if token == "" {
+		token = os.Getenv("DESEC_TOKEN")
+	}
+	if token == "" {
+		return nil, errors.New("no API token specified, use -desec-token")
+	}
+	return &desecProvider{client: client, token: token, minTTLs: make(map[string]int)}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *desecProvider) String() string {
+	return "desec"
+}
+
+// desecRecordSet is a record set as represented by the deSEC API.
+type desecRecordSet struct {
+	Subname string   `json:"subname,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	TTL     int      `json:"ttl"`
+	Records []string `json:"records"`
+}
+
+// verify implements provider, checking that the domains are accessible with the
+// configured token, retrieving their minimum TTLs too.
+func (p *desecProvider) verify(domains []*domain) error {
+	for _, host := range domains {
+		zone, err := hostZone(host)
+		if err != nil {
+			return err
+		}
+		if _, err := p.minTTL(zone); err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 404") {
+				return fmt.Errorf("domain %s not accessible: %v", zone, err)
+			}
+			logErrorf("Failed to verify domain of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record set of a domain to hold the
+// given address, according to the configured policy for multiple records.
+func (p *desecProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = hostZone(host); err != nil {
+			return false, err
+		}
+	}
+	minimum, err := p.minTTL(host.zoneID)
+	if err != nil {
+		return false, fmt.Errorf("domain retrieval failed: %v", err)
+	}
+	var (
+		endpoint = "/domains/" + url.PathEscape(host.zoneID) + "/rrsets/"
+		subname  = relativeName(host.name, host.zoneID)
+
+		set    desecRecordSet
+		exists = true
+	)
+	if err := p.call("GET", endpoint+url.PathEscape(subname)+"/"+kind+"/", nil, &set); err != nil {
+		if !strings.Contains(err.Error(), "HTTP status 404") {
+			return false, fmt.Errorf("record set retrieval failed: %v", err)
+		}
+		if !*createFlag {
+			return false, fmt.Errorf("no %s record set found for %s, use -create to add it", kind, host)
+		}
+		exists = false
+	}
+	// Assemble the new record set, raising the TTL to the domain's minimum
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl < minimum {
+		ttl = minimum
+	}
+	update := desecRecordSet{TTL: ttl, Records: []string{address}}
+	if !exists {
+		update.Type = kind
+		if subname != "@" {
+			update.Subname = subname
+		}
+		if err := p.call("POST", endpoint, update, nil); err != nil {
+			return false, fmt.Errorf("record set creation failed: %v", err)
+		}
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+		return true, nil
+	}
+	if update.Records, err = mergeValues(set.Records, address, previous); err != nil {
+		return false, err
+	}
+	if set.TTL == update.TTL && strings.Join(set.Records, ",") == strings.Join(update.Records, ",") {
+		return false, nil
+	}
+	if err := p.call("PATCH", endpoint+url.PathEscape(subname)+"/"+kind+"/", update, nil); err != nil {
+		return false, fmt.Errorf("record set update failed: %v", err)
+	}
+	return true, nil
+}
+
+// minTTL retrieves the minimum TTL allowed for the records of a domain, caching
+// it after the first lookup.
+func (p *desecProvider) minTTL(zone string) (int, error) {
+	p.lock.Lock()
+	ttl, ok := p.minTTLs[zone]
+	p.lock.Unlock()
+
+	if ok {
+		return ttl, nil
+	}
+	var info struct {
+		MinimumTTL int `json:"minimum_ttl"`
+	}
+	if err := p.call("GET", "/domains/"+url.PathEscape(zone)+"/", nil, &info); err != nil {
+		return 0, err
+	}
+	p.lock.Lock()
+	p.minTTLs[zone] = info.MinimumTTL
+	p.lock.Unlock()
+
+	return info.MinimumTTL, nil
+}
+
+// call executes an authorized deSEC API request.
+func (p *desecProvider) call(method string, path string, payload interface{}, result interface{}) error {
+	if simulated(method, desecEndpoint+path, payload) {
+		return nil
+	}
+	return jsonCall(p.client, method, desecEndpoint+path, http.Header{"Authorization": {"Token " + p.token}}, payload, result)
+}
@@ -0,0 +1,154 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// digitalOceanEndpoint is the base URL of the DigitalOcean API.
+const digitalOceanEndpoint = "https://api.digitalocean.com/v2"
+
+// digitalOceanTTL is the TTL DigitalOcean assigns to records by default, used in
+// place of automatic TTLs.
+const digitalOceanTTL = 1800
+
+// digitalOceanProvider is the DigitalOcean DNS provider, updating the records of
+// domains through the REST API, authenticated with a personal access token.
+type digitalOceanProvider struct {
+	client *http.Client // HTTP client to execute the API calls with
+	token  string       // Personal access token with write scope
+}
+
+// newDigitalOceanProvider creates a DigitalOcean DNS provider with the given
+// access token, falling back to the environment variables used by doctl.
+func newDigitalOceanProvider(client *http.Client, token string) (*digitalOceanProvider, error) {
+	for _, env := range []string{"DIGITALOCEAN_TOKEN", "DIGITALOCEAN_ACCESS_TOKEN"} {
+		if token == "" {
+			token = os.Getenv(env)
+		}
+	}
+	if token == "" {
+		return nil, errors.New("no access token specified, use -do-token")
+	}
+	return &digitalOceanProvider{client: client, token: token}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *digitalOceanProvider) String() string {
+	return "digitalocean"
+}
+
+// digitalOceanRecord is a domain record as represented by the DigitalOcean API.
+type digitalOceanRecord struct {
+	ID   int    `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl"`
+}
+
+// verify implements provider, checking that the domains are accessible with the
+// configured token.
+func (p *digitalOceanProvider) verify(domains []*domain) error {
+	for _, host := range domains {
+		zone, err := hostZone(host)
+		if err != nil {
+			return err
+		}
+		if err := p.call("GET", "/domains/"+url.PathEscape(zone), nil, nil); err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 404") {
+				return fmt.Errorf("domain %s not accessible (missing write scope?): %v", zone, err)
+			}
+			logErrorf("Failed to verify domain of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record of a domain to the given
+// address, according to the configured policy for multiple records.
+func (p *digitalOceanProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = hostZone(host); err != nil {
+			return false, err
+		}
+	}
+	endpoint := "/domains/" + url.PathEscape(host.zoneID) + "/records"
+
+	query := url.Values{}
+	query.Set("type", kind)
+	query.Set("name", host.name)
+	query.Set("per_page", "200")
+
+	var reply struct {
+		Records []digitalOceanRecord `json:"domain_records"`
+	}
+	if err := p.call("GET", endpoint+"?"+query.Encode(), nil, &reply); err != nil {
+		return false, fmt.Errorf("record retrieval failed: %v", err)
+	}
+	if len(reply.Records) == 0 && !*createFlag {
+		return false, fmt.Errorf("invalid number of DNS records found: %+v", reply.Records)
+	}
+	values := make([]string, len(reply.Records))
+	for i, rec := range reply.Records {
+		values[i] = rec.Data
+	}
+	index, stale, err := pickValue(values, address, previous)
+	if err != nil {
+		return false, err
+	}
+	// Assemble the new record, DigitalOcean having no notion of automatic TTLs
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl == 1 {
+		ttl = digitalOceanTTL
+	}
+	update := digitalOceanRecord{Type: kind, Name: relativeName(host.name, host.zoneID), Data: address, TTL: ttl}
+
+	changed := len(stale) > 0
+	switch {
+	case index < 0:
+		if err := p.call("POST", endpoint, update, nil); err != nil {
+			return false, fmt.Errorf("dns record creation failed: %v", err)
+		}
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+		changed = true
+
+	case reply.Records[index].Data != address || reply.Records[index].TTL != ttl:
+		if err := p.call("PUT", endpoint+"/"+strconv.Itoa(reply.Records[index].ID), update, nil); err != nil {
+			return false, fmt.Errorf("dns record update failed: %v", err)
+		}
+		changed = true
+	}
+	// Delete the records made superfluous by the multiple records policy
+	for _, i := range stale {
+		if err := p.call("DELETE", endpoint+"/"+strconv.Itoa(reply.Records[i].ID), nil, nil); err != nil {
+			logErrorf("Failed to delete superfluous record of %s (%s): %v", host, values[i], err)
+			continue
+		}
+		logInfof("Deleted superfluous record of %s (%s)", host, values[i])
+	}
+	return changed, nil
+}
+
+// call executes an authorized DigitalOcean API request.
+func (p *digitalOceanProvider) call(method string, path string, payload interface{}, result interface{}) error {
+	if simulated(method, digitalOceanEndpoint+path, payload) {
+		return nil
+	}
+	return jsonCall(p.client, method, digitalOceanEndpoint+path, http.Header{"Authorization": {"Bearer " + p.token}}, payload, result)
+}
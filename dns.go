@@ -0,0 +1,77 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	dnsDefaultPort = "53"            // Default DNS port if none was specified
+	dnsTimeout     = 5 * time.Second // Time to wait for a DNS query to complete
+)
+
+// queryDNS retrieves the external IP address of the machine by querying special
+// records of a DNS server that reflect the address of the requester. The server
+// is addressed by an RFC 4501 style URI of the form dns://server/name?type=TXT,
+// where the type is optional and defaults to A or AAAA depending on the family.
+func queryDNS(ctx context.Context, dial dialFunc, endpoint *url.URL, ipv6 bool) (string, error) {
+	// Assemble the server and record to query
+	server := endpoint.Host
+	if server == "" {
+		return "", errors.New("missing dns server")
+	}
+	if endpoint.Port() == "" {
+		server = net.JoinHostPort(endpoint.Hostname(), dnsDefaultPort)
+	}
+	name := strings.Trim(endpoint.Path, "/")
+	if name == "" {
+		return "", errors.New("missing dns record name")
+	}
+	name += "."
+
+	network := "4"
+	if ipv6 {
+		network = "6"
+	}
+	// Create a resolver that sends all queries to the requested server
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, proto, address string) (net.Conn, error) {
+			return dial(ctx, proto[:3]+network, server)
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	switch kind := strings.ToUpper(endpoint.Query().Get("type")); kind {
+	case "", "A", "AAAA":
+		ips, err := resolver.LookupIP(ctx, "ip"+network, name)
+		if err != nil {
+			return "", err
+		}
+		return ips[0].String(), nil
+
+	case "TXT":
+		txts, err := resolver.LookupTXT(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		if len(txts) == 0 {
+			return "", errors.New("no txt records found")
+		}
+		return strings.TrimSpace(txts[0]), nil
+
+	default:
+		return "", fmt.Errorf("unsupported dns record type: %q", kind)
+	}
+}
@@ -0,0 +1,214 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// domain is a single DNS name to maintain, along with any record settings to
+// enforce on it on top of the address itself.
+type domain struct {
+	name    string // Fully qualified name of the DNS record to update
+	zone    string // Explicit zone name or ID of the record (empty = derive from name)
+	ttl     int    // Time to live of the record (0 = use the global default)
+	proxied *bool  // Whether to route through CloudFlare's proxy (nil = keep as is)
+	token   string // API token of the account owning the domain (empty = default)
+	backend string // Name of the DNS provider hosting the domain (empty = CloudFlare)
+	fanout  bool   // Whether the same name is also updated on other providers
+
+	kinds []string // Record types to maintain (empty = all enabled families)
+
+	provider provider // DNS provider to update the domain with
+
+	zoneID  string                          // Cached CloudFlare ID of the zone, once resolved
+	records map[string]cloudflare.DNSRecord // Cached CloudFlare records by type, once resolved
+	owned   bool                            // Whether the ownership of the domain was confirmed
+
+	published map[string]string // Addresses last published successfully, by record type
+}
+
+// String implements fmt.Stringer, returning the record name of the domain, along
+// with its provider if the name is updated on multiple ones.
+func (d *domain) String() string {
+	if !d.fanout {
+		return d.name
+	}
+	if d.backend == "" {
+		return d.name + " via cloudflare"
+	}
+	return d.name + " via " + d.backend
+}
+
+// parseDomains parses a comma separated list of domains, each optionally having
+// colon separated key=value settings attached (e.g. www.example.com:proxied=true).
+// Domains with multiple providers (e.g. provider=cloudflare+route53) are expanded
+// into one domain per provider, each updated and retried independently.
+func parseDomains(list string) ([]*domain, error) {
+	var domains []*domain
+	for _, spec := range splitList(list) {
+		parts := strings.Split(spec, ":")
+
+		var (
+			d        = &domain{name: normalizeName(parts[0]), zone: normalizeName(*zoneFlag)}
+			backends = []string{""}
+		)
+		if d.name == "" {
+			return nil, fmt.Errorf("empty domain name: %s", spec)
+		}
+		if strings.Contains(strings.TrimPrefix(d.name, "*."), "*") {
+			return nil, fmt.Errorf("wildcard only allowed as the first label: %s", d.name)
+		}
+		for _, option := range parts[1:] {
+			kv := strings.SplitN(option, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid option of %s, expected key=value: %s", d.name, option)
+			}
+			switch kv[0] {
+			case "zone":
+				d.zone = normalizeName(kv[1])
+
+			case "ttl":
+				ttl, err := parseTTL(kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid ttl option of %s: %v", d.name, err)
+				}
+				d.ttl = ttl
+
+			case "proxied":
+				proxied, err := strconv.ParseBool(kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid proxied option of %s: %v", d.name, err)
+				}
+				d.proxied = &proxied
+
+			case "provider":
+				backends = backends[:0]
+				for _, backend := range strings.Split(strings.ToLower(kv[1]), "+") {
+					if backend = strings.TrimSpace(backend); backend == "cloudflare" {
+						backend = ""
+					}
+					if contains(backends, backend) {
+						return nil, fmt.Errorf("duplicate provider of %s: %s", d.name, kv[1])
+					}
+					backends = append(backends, backend)
+				}
+
+			case "types":
+				d.kinds = nil
+				for _, kind := range strings.Split(strings.ToUpper(kv[1]), "+") {
+					if kind = strings.TrimSpace(kind); kind != "A" && kind != "AAAA" {
+						return nil, fmt.Errorf("invalid record type of %s, expected A or AAAA: %s", d.name, kind)
+					}
+					if !contains(d.kinds, kind) {
+						d.kinds = append(d.kinds, kind)
+					}
+				}
+
+			default:
+				return nil, fmt.Errorf("unknown option of %s: %s", d.name, kv[0])
+			}
+		}
+		if d.proxied != nil && !contains(backends, "") {
+			return nil, fmt.Errorf("domain %s not hosted on CloudFlare cannot be proxied", d.name)
+		}
+		if d.proxied != nil && *d.proxied && d.ttl > 1 {
+			return nil, fmt.Errorf("proxied domain %s cannot have a custom ttl, only auto", d.name)
+		}
+		// Create an independent copy of the domain for each of its providers,
+		// applying the CloudFlare specific settings only where they make sense
+		for _, backend := range backends {
+			mirror := *d
+			mirror.backend, mirror.fanout = backend, len(backends) > 1
+			if backend != "" {
+				mirror.proxied = nil
+			}
+			domains = append(domains, &mirror)
+		}
+	}
+	return domains, nil
+}
+
+// maintains reports whether the records of the given type are to be maintained
+// for the domain.
+func (d *domain) maintains(kind string) bool {
+	return len(d.kinds) == 0 || contains(d.kinds, kind)
+}
+
+// contains reports whether a list of strings contains the given item.
+func contains(items []string, item string) bool {
+	for _, known := range items {
+		if known == item {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTTL parses a DNS record TTL, either as a number of seconds within the
+// range accepted by CloudFlare, or as "auto" (encoded as 1).
+func parseTTL(value string) (int, error) {
+	if value == "auto" {
+		return 1, nil
+	}
+	ttl, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q, expected seconds or auto", value)
+	}
+	if ttl != 1 && (ttl < 30 || ttl > 86400) {
+		return 0, fmt.Errorf("ttl %d out of range, expected 30-86400 or auto", ttl)
+	}
+	return ttl, nil
+}
+
+// ttlValue is a flag.Value accepting a DNS record TTL in seconds or as "auto".
+type ttlValue int
+
+// newTTLFlag defines a TTL flag with the specified name, default value and usage,
+// returning the address of the variable storing its value (akin to flag.Int).
+func newTTLFlag(name string, value int, usage string) *int {
+	flag.Var((*ttlValue)(&value), name, usage)
+	return &value
+}
+
+// String implements flag.Value, returning the TTL in its textual form.
+func (v *ttlValue) String() string {
+	switch {
+	case v == nil:
+		return ""
+	case *v == 1:
+		return "auto"
+	default:
+		return strconv.Itoa(int(*v))
+	}
+}
+
+// Set implements flag.Value, parsing a TTL into the flag.
+func (v *ttlValue) Set(value string) error {
+	ttl, err := parseTTL(value)
+	if err != nil {
+		return err
+	}
+	*v = ttlValue(ttl)
+	return nil
+}
+
+// normalizeName converts a DNS name into the canonical form used by CloudFlare,
+// lowercase, punycode encoded and without the trailing root dot. Without this,
+// the zone apex in its fully qualified form (e.g. example.com.) would be mistaken
+// for a TLD, and internationalized names would never match.
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if ascii, err := toASCII(name); err == nil {
+		name = ascii
+	}
+	return name
+}
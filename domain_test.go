@@ -0,0 +1,102 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describeDomain flattens the parsed settings of a domain for comparisons.
+func describeDomain(d *domain) string {
+	proxied := "keep"
+	if d.proxied != nil {
+		proxied = fmt.Sprint(*d.proxied)
+	}
+	return fmt.Sprintf("%s zone=%s ttl=%d proxied=%s backend=%s fanout=%v types=%s",
+		d.name, d.zone, d.ttl, proxied, d.backend, d.fanout, strings.Join(d.kinds, "+"))
+}
+
+// Tests that domain lists with their per domain settings are parsed correctly.
+func TestParseDomains(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+		fail bool
+	}{
+		{list: "", want: nil},
+		{
+			list: "www.example.com, Example.ORG.",
+			want: []string{
+				"www.example.com zone= ttl=0 proxied=keep backend= fanout=false types=",
+				"example.org zone= ttl=0 proxied=keep backend= fanout=false types=",
+			},
+		},
+		{
+			list: "www.example.com:zone=example.com:ttl=120:proxied=false",
+			want: []string{"www.example.com zone=example.com ttl=120 proxied=false backend= fanout=false types="},
+		},
+		{
+			list: "www.example.com:ttl=auto:proxied=true",
+			want: []string{"www.example.com zone= ttl=1 proxied=true backend= fanout=false types="},
+		},
+		{
+			list: "*.example.com:types=aaaa",
+			want: []string{"*.example.com zone= ttl=0 proxied=keep backend= fanout=false types=AAAA"},
+		},
+		{
+			list: "www.example.com:types=A+aaaa+A",
+			want: []string{"www.example.com zone= ttl=0 proxied=keep backend= fanout=false types=A+AAAA"},
+		},
+		{
+			list: "www.example.com:provider=route53",
+			want: []string{"www.example.com zone= ttl=0 proxied=keep backend=route53 fanout=false types="},
+		},
+		{
+			list: "www.example.com:proxied=false:provider=CloudFlare+route53",
+			want: []string{
+				"www.example.com zone= ttl=0 proxied=false backend= fanout=true types=",
+				"www.example.com zone= ttl=0 proxied=keep backend=route53 fanout=true types=",
+			},
+		},
+		{
+			list: "bücher.example.com",
+			want: []string{"xn--bcher-kva.example.com zone= ttl=0 proxied=keep backend= fanout=false types="},
+		},
+		{list: ":ttl=120", fail: true},
+		{list: "www.*.example.com", fail: true},
+		{list: "www.example.com:ttl", fail: true},
+		{list: "www.example.com:ttl=10", fail: true},
+		{list: "www.example.com:ttl=forever", fail: true},
+		{list: "www.example.com:proxied=maybe", fail: true},
+		{list: "www.example.com:types=MX", fail: true},
+		{list: "www.example.com:color=blue", fail: true},
+		{list: "www.example.com:provider=route53+route53", fail: true},
+		{list: "www.example.com:provider=route53:proxied=true", fail: true},
+		{list: "www.example.com:proxied=true:ttl=120", fail: true},
+	}
+	for _, tt := range tests {
+		domains, err := parseDomains(tt.list)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected failure, got %v", tt.list, domains)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: failed to parse: %v", tt.list, err)
+			continue
+		}
+		var have []string
+		for _, d := range domains {
+			have = append(have, describeDomain(d))
+		}
+		if strings.Join(have, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%q: domains mismatch:\nhave %q\nwant %q", tt.list, have, tt.want)
+		}
+	}
+}
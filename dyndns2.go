@@ -0,0 +1,149 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// dyndns2Errors are the failure codes of the DynDNS2 protocol, and whether they
+// are permanent, requiring the client to stop updating until reconfigured.
+var dyndns2Errors = map[string]bool{
+	"badauth":  true,  // Invalid username or password
+	"badagent": true,  // Client blocked by the service
+	"!donator": true,  // Feature only available to paying users
+	"notfqdn":  true,  // Hostname is not a fully qualified domain name
+	"nohost":   true,  // Hostname does not exist in the account
+	"numhost":  true,  // Too many hosts in a single update
+	"abuse":    true,  // Hostname blocked for abuse
+	"dnserr":   false, // Server side DNS error, retry later
+	"911":      false, // Server side maintenance, retry later
+}
+
+// dyndns2Provider is a client of the DynDNS2 update protocol, spoken by most
+// dynamic DNS services (e.g. No-IP, Dyn, Dynu or Google Domains style endpoints).
+// Hosts are updated one by one via HTTP basic authenticated GET requests.
+type dyndns2Provider struct {
+	client   *http.Client // HTTP client to execute the updates with
+	endpoint *url.URL     // Update URL of the service (e.g. https://host/nic/update)
+	user     string       // Username (or account email) of the service
+	password string       // Password (or update token) of the service
+
+	blocked map[string]error // Hosts refused permanently, not to be retried
+	lock    sync.Mutex
+}
+
+// newDynDNS2Provider creates a DynDNS2 protocol client for the given service.
+func newDynDNS2Provider(client *http.Client, server string, user string, password string) (*dyndns2Provider, error) {
+	if server == "" {
+		return nil, errors.New("no update URL specified, use -dyndns2-url")
+	}
+	endpoint, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid update URL: %v", err)
+	}
+	if endpoint.Scheme != "https" && endpoint.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported update URL scheme: %s", endpoint.Scheme)
+	}
+	if endpoint.User != nil {
+		// Credentials embedded in the URL are used unless given explicitly
+		if user == "" {
+			user = endpoint.User.Username()
+		}
+		if pass, ok := endpoint.User.Password(); ok && password == "" {
+			password = pass
+		}
+		endpoint.User = nil
+	}
+	return &dyndns2Provider{
+		client:   client,
+		endpoint: endpoint,
+		user:     user,
+		password: password,
+		blocked:  make(map[string]error),
+	}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *dyndns2Provider) String() string {
+	return "dyndns2"
+}
+
+// verify implements provider. The protocol has no means of checking credentials
+// without updating a host (which services penalize if unchanged), so nothing to
+// do here besides ensuring some are configured.
+func (p *dyndns2Provider) verify(domains []*domain) error {
+	if p.user == "" || p.password == "" {
+		return errors.New("no credentials specified, use -dyndns2-user and -dyndns2-password")
+	}
+	return nil
+}
+
+// upsert implements provider, pushing the new address of a host to the service.
+// Record sets, TTLs and multiple record policies are all managed by the service
+// itself, so only the address is sent.
+func (p *dyndns2Provider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	p.lock.Lock()
+	err := p.blocked[host.name]
+	p.lock.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+	query := p.endpoint.Query()
+	query.Set("hostname", host.name)
+	query.Set("myip", address)
+
+	endpoint := *p.endpoint
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", endpoint.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	if *dryRunFlag {
+		logInfof("Dry run, not sending: GET %s hostname=%s myip=%s", p.endpoint.Scheme+"://"+p.endpoint.Host+p.endpoint.Path, host.name, address)
+		return true, nil
+	}
+	req.SetBasicAuth(p.user, p.password)
+	req.Header.Set("User-Agent", *userAgentFlag)
+
+	res, err := apiCall(p.client, req)
+	if err != nil {
+		return false, fmt.Errorf("dyndns update failed: %v", err)
+	}
+	// Interpret the first word of the reply, ignoring the echoed address
+	fields := strings.Fields(string(res))
+	if len(fields) == 0 {
+		return false, errors.New("empty dyndns reply")
+	}
+	switch code := fields[0]; code {
+	case "good":
+		return true, nil
+	case "nochg":
+		return false, nil
+	default:
+		permanent, known := dyndns2Errors[code]
+		if !known {
+			return false, fmt.Errorf("unexpected dyndns reply: %s", strings.TrimSpace(string(res)))
+		}
+		err := fmt.Errorf("dyndns service replied %s", code)
+		if permanent {
+			// The protocol requires clients to stop updating on permanent errors
+			err = fmt.Errorf("dyndns service replied %s, not retrying until restarted", code)
+
+			p.lock.Lock()
+			p.blocked[host.name] = err
+			p.lock.Unlock()
+		}
+		return false, err
+	}
+}
@@ -0,0 +1,79 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Headers identifying age encrypted files, in binary and ASCII armored form.
+const (
+	ageHeader        = "age-encryption.org/v1\n"
+	ageArmoredHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+)
+
+// readConfig loads a configuration file, decrypting it if it was encrypted with
+// age or SOPS, so complete configurations (including the secrets within) can be
+// kept in version control. Decryption is delegated to the age and sops tools,
+// using the identity given via -config-key, if any.
+func readConfig(path string) ([]byte, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(blob, []byte(ageHeader)) || bytes.HasPrefix(bytes.TrimSpace(blob), []byte(ageArmoredHeader)):
+		if *configKeyFlag == "" {
+			return nil, errors.New("age encrypted config, but no identity specified via -config-key")
+		}
+		checkSecretFile(*configKeyFlag)
+		return decryptConfig(exec.Command("age", "--decrypt", "--identity", *configKeyFlag, path))
+
+	case sopsEncrypted(blob):
+		cmd := exec.Command("sops", "--decrypt", "--input-type", "binary", "--output-type", "binary", path)
+		if *configKeyFlag != "" {
+			checkSecretFile(*configKeyFlag)
+			cmd.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+*configKeyFlag)
+		}
+		return decryptConfig(cmd)
+	}
+	return blob, nil
+}
+
+// sopsEncrypted reports whether the contents of a file were encrypted by SOPS.
+// TOML is not a format SOPS understands, so configs are encrypted as binary data,
+// stored as a JSON document along with the SOPS metadata.
+func sopsEncrypted(blob []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(blob), []byte("{")) {
+		return false
+	}
+	var doc struct {
+		Data string          `json:"data"`
+		Sops json.RawMessage `json:"sops"`
+	}
+	return json.Unmarshal(blob, &doc) == nil && doc.Data != "" && len(doc.Sops) > 0
+}
+
+// decryptConfig runs an external decryption tool, returning its output.
+func decryptConfig(cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s decryption failed: %v: %s", cmd.Args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s decryption failed: %v", cmd.Args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
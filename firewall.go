@@ -0,0 +1,167 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// listComment is the comment marking the IP list items managed by the updater.
+const listComment = "cloudflare-dyndns"
+
+// accessRule is a CloudFlare IP Access rule, identified by its notes, whose
+// address is kept in sync with the external address of the default uplink.
+type accessRule struct {
+	notes string          // Notes of the access rule, used as its name
+	api   *cloudflare.API // CloudFlare client to update the rule with
+}
+
+// String implements fmt.Stringer, returning a descriptive name of the rule.
+func (r *accessRule) String() string {
+	return "access rule " + r.notes
+}
+
+// endpoint returns the API endpoint of the access rules, defined at the account
+// level if an account was configured, or at the user level otherwise.
+func (r *accessRule) endpoint() string {
+	if *accountIDFlag != "" {
+		return "/accounts/" + *accountIDFlag + "/firewall/access_rules/rules"
+	}
+	return "/user/firewall/access_rules/rules"
+}
+
+// update implements target, replacing the access rule of the given family with
+// one allowing the new address. The configuration of a rule cannot be modified,
+// so a new one is created first (keeping the mode of the old), then the old one
+// is deleted. Missing rules are created in whitelist mode.
+func (r *accessRule) update(address string, ipv6 bool) (bool, error) {
+	kind := "ip"
+	if ipv6 {
+		kind = "ip6"
+	}
+	// Look up any existing rules with the configured notes
+	rules, err := r.list(kind)
+	if err != nil {
+		return false, fmt.Errorf("rule retrieval failed: %v", err)
+	}
+	var (
+		mode  = "whitelist"
+		stale []string
+	)
+	for _, rule := range rules {
+		if rule.Notes != r.notes || rule.Configuration.Target != kind {
+			continue
+		}
+		if rule.Configuration.Value == address {
+			return true, nil
+		}
+		mode, stale = rule.Mode, append(stale, rule.ID)
+	}
+	// Create the replacement rule and delete the stale ones
+	rule := map[string]interface{}{
+		"mode":          mode,
+		"notes":         r.notes,
+		"configuration": map[string]string{"target": kind, "value": address},
+	}
+	if _, err := callAPI(r.api, "POST", r.endpoint(), rule); err != nil {
+		return false, fmt.Errorf("rule creation failed: %v", err)
+	}
+	for _, id := range stale {
+		if _, err := callAPI(r.api, "DELETE", r.endpoint()+"/"+id, nil); err != nil {
+			return false, fmt.Errorf("stale rule deletion failed: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// list retrieves the access rules with the configured notes and target kind,
+// requesting the pages one by one (each under its own deadline) until the last
+// one reported by the API.
+func (r *accessRule) list(kind string) ([]cloudflare.AccessRule, error) {
+	filter := cloudflare.AccessRule{
+		Notes:         r.notes,
+		Configuration: cloudflare.AccessRuleConfiguration{Target: kind},
+	}
+	var rules []cloudflare.AccessRule
+	for page := 1; ; page++ {
+		if page > listPageLimit {
+			return nil, fmt.Errorf("too many access rule pages: more than %d", listPageLimit)
+		}
+		var (
+			ctx, cancel = apiContext()
+			res         *cloudflare.AccessRuleListResponse
+			err         error
+		)
+		if *accountIDFlag != "" {
+			res, err = r.api.ListAccountAccessRules(ctx, *accountIDFlag, filter, page)
+		} else {
+			res, err = r.api.ListUserAccessRules(ctx, filter, page)
+		}
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, res.Result...)
+		if page >= res.TotalPages {
+			return rules, nil
+		}
+	}
+}
+
+// ipList is a CloudFlare IP list (used by custom firewall rules), whose items
+// added by the updater are kept in sync with the external address of the
+// default uplink. Items added by other means are left alone.
+type ipList struct {
+	id  string          // Identifier of the IP list
+	api *cloudflare.API // CloudFlare client to update the list with
+}
+
+// String implements fmt.Stringer, returning a descriptive name of the list.
+func (l *ipList) String() string {
+	return "IP list " + l.id
+}
+
+// update implements target, replacing the list item of the given family with the
+// new address.
+func (l *ipList) update(address string, ipv6 bool) (bool, error) {
+	endpoint := "/accounts/" + *accountIDFlag + "/rules/lists/" + l.id + "/items"
+
+	// The items are cursor paginated, which the client follows to the last page
+	ctx, cancel := apiContext()
+	items, err := l.api.ListListItems(ctx, cloudflare.AccountIdentifier(*accountIDFlag), cloudflare.ListListItemsParams{ID: l.id})
+	cancel()
+	if err != nil {
+		return false, fmt.Errorf("list retrieval failed: %v", err)
+	}
+	// Collect the stale items of the same family managed by the updater
+	type itemID struct {
+		ID string `json:"id"`
+	}
+	var stale []itemID
+	for _, item := range items {
+		if item.IP == nil || item.Comment != listComment || strings.Contains(*item.IP, ":") != ipv6 {
+			continue
+		}
+		if *item.IP == address {
+			return true, nil
+		}
+		stale = append(stale, itemID{item.ID})
+	}
+	// Add the new address and drop the stale ones
+	item := []map[string]string{{"ip": address, "comment": listComment}}
+	if _, err := callAPI(l.api, "POST", endpoint, item); err != nil {
+		return false, fmt.Errorf("list item creation failed: %v", err)
+	}
+	if len(stale) > 0 {
+		if _, err := callAPI(l.api, "DELETE", endpoint, map[string]interface{}{"items": stale}); err != nil {
+			return false, fmt.Errorf("stale list item deletion failed: %v", err)
+		}
+	}
+	return true, nil
+}
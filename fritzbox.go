@@ -0,0 +1,66 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	fritzboxHost    = "fritz.box"                                      // Default hostname of a Fritz!Box on its LAN
+	fritzboxPort    = "49000"                                          // Port of the TR-064/UPnP services on a Fritz!Box
+	fritzboxControl = "/igdupnp/control/WANIPConn1"                    // Control endpoint of the WAN IP connection service
+	fritzboxService = "urn:schemas-upnp-org:service:WANIPConnection:1" // Service type of the WAN IP connection service
+)
+
+// queryFritzBox retrieves the external IP address of the machine by asking an AVM
+// Fritz!Box for its WAN address via its TR-064/UPnP IGD service. For IPv6 there
+// is no NAT, so the box is asked for the prefix delegated to it instead (via the
+// AVM specific extension), and the machine's own address within it is returned.
+// The address is looked up on the uplink's interface (or -interface for the
+// default route), and the length of the prefix is recorded for deriving the
+// addresses of other hosts.
+func queryFritzBox(iface string, ipv6 bool) (string, error) {
+	host := *gatewayFlag
+	if host == "" {
+		host = fritzboxHost
+	}
+	control := "http://" + net.JoinHostPort(host, fritzboxPort) + fritzboxControl
+
+	if ipv6 {
+		var reply struct {
+			Prefix string `xml:"NewIPv6Prefix"`
+			Length int    `xml:"NewPrefixLength"`
+		}
+		if err := soapCall(control, fritzboxService, "X_AVM_DE_GetIPv6Prefix", &reply); err != nil {
+			return "", err
+		}
+		prefix := net.ParseIP(strings.TrimSpace(reply.Prefix))
+		if prefix == nil || prefix.To4() != nil || reply.Length <= 0 || reply.Length > 128 {
+			return "", fmt.Errorf("invalid delegated prefix: %s/%d", reply.Prefix, reply.Length)
+		}
+		recordPrefix(reply.Length)
+
+		if iface == "" {
+			iface = *interfaceFlag
+		}
+		network := &net.IPNet{IP: prefix, Mask: net.CIDRMask(reply.Length, 128)}
+		address, err := queryLocal(iface, network.String(), true)
+		if err != nil {
+			return "", fmt.Errorf("delegated prefix %s: %v", network, err)
+		}
+		return address, nil
+	}
+	var reply struct {
+		Address string `xml:"NewExternalIPAddress"`
+	}
+	if err := soapCall(control, fritzboxService, "GetExternalIPAddress", &reply); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(reply.Address), nil
+}
@@ -0,0 +1,134 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// gandiEndpoint is the base URL of the Gandi LiveDNS API.
+const gandiEndpoint = "https://api.gandi.net/v5/livedns"
+
+// Limits of the record TTLs accepted by Gandi LiveDNS.
+const (
+	gandiMinTTL     = 300   // Minimum TTL of LiveDNS records
+	gandiDefaultTTL = 10800 // Default TTL of LiveDNS records, used for auto
+)
+
+// gandiProvider is the Gandi LiveDNS provider, updating the record sets of
+// domains through the REST API, authenticated with a personal access token.
+type gandiProvider struct {
+	client *http.Client // HTTP client to execute the API calls with
+	token  string       // Personal access token with LiveDNS permissions
+}
+
+// newGandiProvider creates a Gandi LiveDNS provider with the given access token,
+// falling back to the environment if none was specified.
+func newGandiProvider(client *http.Client, token string) (*gandiProvider, error) {
+	if token == "" {
+		token = os.Getenv("GANDI_PAT")
+	}
+	if token == "" {
+		return nil, errors.New("no personal access token specified, use -gandi-token")
+	}
+	return &gandiProvider{client: client, token: token}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *gandiProvider) String() string {
+	return "gandi"
+}
+
+// gandiRecordSet is a record set as represented by the Gandi LiveDNS API.
+type gandiRecordSet struct {
+	TTL    int      `json:"rrset_ttl"`
+	Values []string `json:"rrset_values"`
+}
+
+// verify implements provider, checking that the domains are accessible with the
+// configured token.
+func (p *gandiProvider) verify(domains []*domain) error {
+	for _, host := range domains {
+		zone, err := hostZone(host)
+		if err != nil {
+			return err
+		}
+		if err := p.call("GET", "/domains/"+url.PathEscape(zone), nil, nil); err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 404") {
+				return fmt.Errorf("domain %s not accessible (missing technical configuration permission?): %v", zone, err)
+			}
+			logErrorf("Failed to verify domain of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record set of a domain to hold the
+// given address, according to the configured policy for multiple records.
+func (p *gandiProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = hostZone(host); err != nil {
+			return false, err
+		}
+	}
+	endpoint := "/domains/" + url.PathEscape(host.zoneID) + "/records/" + url.PathEscape(relativeName(host.name, host.zoneID)) + "/" + kind
+
+	var (
+		set    gandiRecordSet
+		exists = true
+	)
+	if err := p.call("GET", endpoint, nil, &set); err != nil {
+		if !strings.Contains(err.Error(), "HTTP status 404") {
+			return false, fmt.Errorf("record set retrieval failed: %v", err)
+		}
+		if !*createFlag {
+			return false, fmt.Errorf("no %s record set found for %s, use -create to add it", kind, host)
+		}
+		exists = false
+	}
+	// Assemble the new record set within the TTL limits of LiveDNS
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	switch {
+	case ttl == 1:
+		ttl = gandiDefaultTTL
+	case ttl < gandiMinTTL:
+		ttl = gandiMinTTL
+	}
+	update := gandiRecordSet{TTL: ttl, Values: []string{address}}
+	if exists {
+		if update.Values, err = mergeValues(set.Values, address, previous); err != nil {
+			return false, err
+		}
+		if set.TTL == update.TTL && strings.Join(set.Values, ",") == strings.Join(update.Values, ",") {
+			return false, nil
+		}
+	}
+	if err := p.call("PUT", endpoint, update, nil); err != nil {
+		return false, fmt.Errorf("record set update failed: %v", err)
+	}
+	if !exists {
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+	}
+	return true, nil
+}
+
+// call executes an authorized Gandi LiveDNS API request.
+func (p *gandiProvider) call(method string, path string, payload interface{}, result interface{}) error {
+	if simulated(method, gandiEndpoint+path, payload) {
+		return nil
+	}
+	return jsonCall(p.client, method, gandiEndpoint+path, http.Header{"Authorization": {"Bearer " + p.token}}, payload, result)
+}
@@ -0,0 +1,291 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleEndpoint is the base URL of the Google Cloud DNS API.
+const googleEndpoint = "https://dns.googleapis.com/dns/v1/projects/"
+
+// googleScope is the OAuth2 scope needed to manage Cloud DNS record sets.
+const googleScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+// googleProvider is the Google Cloud DNS provider, updating the record sets of
+// managed zones through the REST API, authenticated as a service account.
+type googleProvider struct {
+	client  *http.Client // HTTP client to execute the API calls with
+	project string       // Project owning the managed zones
+
+	email    string          // Email address of the service account
+	key      *rsa.PrivateKey // Private key of the service account to sign grants with
+	tokenURI string          // OAuth2 endpoint to exchange signed grants at
+
+	token   string    // Current OAuth2 access token
+	expires time.Time // Expiration time of the current access token
+	lock    sync.Mutex
+}
+
+// newGoogleProvider creates a Google Cloud DNS provider, authenticated with the
+// given service account key file (or the one pointed to by the standard env var).
+func newGoogleProvider(client *http.Client, keyfile string, project string) (*googleProvider, error) {
+	if keyfile == "" {
+		keyfile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if keyfile == "" {
+		return nil, errors.New("no service account key specified, use -gcp-credentials")
+	}
+	blob, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account key: %v", err)
+	}
+	var account struct {
+		Type       string `json:"type"`
+		ProjectID  string `json:"project_id"`
+		Email      string `json:"client_email"`
+		PrivateKey string `json:"private_key"`
+		TokenURI   string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(blob, &account); err != nil {
+		return nil, fmt.Errorf("invalid service account key: %v", err)
+	}
+	if account.Type != "service_account" {
+		return nil, fmt.Errorf("unsupported credentials type: %s", account.Type)
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid service account private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("service account private key is not RSA")
+	}
+	if project == "" {
+		project = account.ProjectID
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &googleProvider{
+		client:   client,
+		project:  project,
+		email:    account.Email,
+		key:      key,
+		tokenURI: account.TokenURI,
+	}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *googleProvider) String() string {
+	return "google"
+}
+
+// googleRecordSet is a resource record set as represented by the Cloud DNS API.
+type googleRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+// verify implements provider, checking that the managed zones of all domains are
+// accessible with the service account.
+func (p *googleProvider) verify(domains []*domain) error {
+	if _, err := p.authorize(); err != nil {
+		if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 400") {
+			return fmt.Errorf("service account authentication failed: %v", err)
+		}
+		logErrorf("Failed to authenticate service account: %v", err)
+		return nil
+	}
+	for _, host := range domains {
+		zone, err := p.resolveZone(host)
+		if err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "could not be found") {
+				return fmt.Errorf("managed zone of %s not accessible (missing DNS Administrator role?): %v", host, err)
+			}
+			logErrorf("Failed to verify managed zone of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record set of a domain to hold the
+// given address, according to the configured policy for multiple records.
+func (p *googleProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = p.resolveZone(host); err != nil {
+			return false, fmt.Errorf("managed zone resolution failed: %v", err)
+		}
+	}
+	endpoint := googleEndpoint + p.project + "/managedZones/" + host.zoneID + "/rrsets"
+
+	query := url.Values{}
+	query.Set("name", host.name+".")
+	query.Set("type", kind)
+
+	var sets struct {
+		RRSets []googleRecordSet `json:"rrsets"`
+	}
+	if err := p.call("GET", endpoint+"?"+query.Encode(), nil, &sets); err != nil {
+		host.zoneID = ""
+		return false, fmt.Errorf("record set retrieval failed: %v", err)
+	}
+	if len(sets.RRSets) == 0 && !*createFlag {
+		return false, fmt.Errorf("no %s record set found for %s, use -create to add it", kind, host)
+	}
+	// Assemble the new record set, Cloud DNS having no notion of automatic TTLs
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl == 1 {
+		ttl = 300
+	}
+	update := googleRecordSet{Name: host.name + ".", Type: kind, TTL: ttl, RRDatas: []string{address}}
+	if len(sets.RRSets) == 0 {
+		if err := p.call("POST", endpoint, update, nil); err != nil {
+			return false, fmt.Errorf("record set creation failed: %v", err)
+		}
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+		return true, nil
+	}
+	current := sets.RRSets[0]
+	if update.RRDatas, err = mergeValues(current.RRDatas, address, previous); err != nil {
+		return false, err
+	}
+	if current.TTL == update.TTL && strings.Join(current.RRDatas, ",") == strings.Join(update.RRDatas, ",") {
+		return false, nil
+	}
+	if err := p.call("PATCH", endpoint+"/"+url.PathEscape(update.Name)+"/"+kind, update, nil); err != nil {
+		host.zoneID = ""
+		return false, fmt.Errorf("record set update failed: %v", err)
+	}
+	return true, nil
+}
+
+// resolveZone retrieves the name of the Cloud DNS managed zone of a domain,
+// either from its explicit zone setting (DNS name or managed zone name) or by
+// deriving the zone from the domain name.
+func (p *googleProvider) resolveZone(host *domain) (string, error) {
+	// Managed zone names have no dots, unlike DNS names
+	if host.zone != "" && !strings.Contains(host.zone, ".") {
+		return host.zone, nil
+	}
+	zone, err := hostZone(host)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("dnsName", zone+".")
+
+	var zones struct {
+		ManagedZones []struct {
+			Name    string `json:"name"`
+			DNSName string `json:"dnsName"`
+		} `json:"managedZones"`
+	}
+	if err := p.call("GET", googleEndpoint+p.project+"/managedZones?"+query.Encode(), nil, &zones); err != nil {
+		return "", err
+	}
+	for _, managed := range zones.ManagedZones {
+		if normalizeName(managed.DNSName) == zone {
+			return managed.Name, nil
+		}
+	}
+	return "", fmt.Errorf("managed zone %s could not be found", zone)
+}
+
+// call executes an authorized Cloud DNS API request.
+func (p *googleProvider) call(method string, endpoint string, payload interface{}, result interface{}) error {
+	if simulated(method, endpoint, payload) {
+		return nil
+	}
+	token, err := p.authorize()
+	if err != nil {
+		return err
+	}
+	return jsonCall(p.client, method, endpoint, http.Header{"Authorization": {"Bearer " + token}}, payload, result)
+}
+
+// authorize returns a valid OAuth2 access token of the service account, signing
+// and exchanging a new JWT grant if the previous token expired.
+func (p *googleProvider) authorize() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.token != "" && time.Until(p.expires) > time.Minute {
+		return p.token, nil
+	}
+	// Assemble and sign the JWT grant of the service account
+	now := time.Now()
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   p.email,
+		"scope": googleScope,
+		"aud":   p.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	// Exchange the grant for an access token
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", unsigned+"."+base64.RawURLEncoding.EncodeToString(signature))
+
+	req, err := http.NewRequest("POST", p.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := apiCall(p.client, req)
+	if err != nil {
+		return "", fmt.Errorf("access token request failed: %v", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(res, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid access token response: %s", res)
+	}
+	p.token, p.expires = token.AccessToken, now.Add(time.Duration(token.ExpiresIn)*time.Second)
+	return p.token, nil
+}
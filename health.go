@@ -0,0 +1,128 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	healthDemoteFailures = 3                // Consecutive failures after which a resolver is demoted
+	healthDemoteMin      = 5 * time.Minute  // Initial time a failing resolver is demoted for
+	healthDemoteMax      = 60 * time.Minute // Maximum time a failing resolver is demoted for
+)
+
+// resolverStats is the health record of a single resolution service.
+type resolverStats struct {
+	successes   int           // Number of successful queries
+	failures    int           // Number of failed queries
+	consecutive int           // Number of failed queries since the last success
+	latency     time.Duration // Moving average of the successful query latencies
+	elapsed     time.Duration // Total latency of the successful queries
+	demotion    time.Duration // Duration of the last demotion, doubled on repeats
+	demoted     time.Time     // Time until which the resolver is demoted
+}
+
+// resolverHealth tracks the success rate and latency of the resolution services,
+// demoting the failing ones and rotating the healthy ones to spread the load.
+type resolverHealth struct {
+	stats  map[string]*resolverStats
+	rotate int // Offset to rotate the healthy resolvers by
+	lock   sync.Mutex
+}
+
+// health is the global health tracker of the resolution services.
+var health = &resolverHealth{stats: make(map[string]*resolverStats)}
+
+// order returns the resolvers in the order they should be queried: healthy ones
+// first rotated on every call, followed by the demoted ones, least recently
+// demoted first.
+func (h *resolverHealth) order(resolvers []string) []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var (
+		now     = time.Now()
+		healthy []string
+		demoted []string
+	)
+	for _, resolver := range resolvers {
+		if stats := h.stats[resolver]; stats != nil && stats.demoted.After(now) {
+			demoted = append(demoted, resolver)
+		} else {
+			healthy = append(healthy, resolver)
+		}
+	}
+	if len(healthy) > 0 {
+		offset := h.rotate % len(healthy)
+		healthy = append(healthy[offset:], healthy[:offset]...)
+	}
+	h.rotate++
+
+	sort.SliceStable(demoted, func(i, j int) bool {
+		return h.stats[demoted[i]].demoted.Before(h.stats[demoted[j]].demoted)
+	})
+	return append(healthy, demoted...)
+}
+
+// snapshot returns a copy of the health records of all the resolvers queried.
+func (h *resolverHealth) snapshot() map[string]resolverStats {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	stats := make(map[string]resolverStats, len(h.stats))
+	for resolver, stat := range h.stats {
+		stats[resolver] = *stat
+	}
+	return stats
+}
+
+// record updates the health of a resolver with the outcome of a query.
+func (h *resolverHealth) record(resolver string, latency time.Duration, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	stats := h.stats[resolver]
+	if stats == nil {
+		stats = new(resolverStats)
+		h.stats[resolver] = stats
+	}
+	if err == nil {
+		// Successful query, reinstate the resolver and track its latency
+		if stats.successes == 0 {
+			stats.latency = latency
+		} else {
+			stats.latency = (3*stats.latency + latency) / 4
+		}
+		stats.successes++
+		stats.elapsed += latency
+		stats.consecutive = 0
+		stats.demotion = 0
+		stats.demoted = time.Time{}
+		return
+	}
+	// Failed query, demote the resolver if it keeps failing
+	stats.failures++
+	stats.consecutive++
+	if stats.consecutive < healthDemoteFailures || stats.demoted.After(time.Now()) {
+		return
+	}
+	switch {
+	case stats.demotion == 0:
+		stats.demotion = healthDemoteMin
+	case stats.demotion < healthDemoteMax:
+		stats.demotion *= 2
+		if stats.demotion > healthDemoteMax {
+			stats.demotion = healthDemoteMax
+		}
+	}
+	stats.demoted = time.Now().Add(stats.demotion)
+
+	logWarnf("Demoting resolver %s for %v: %d consecutive failures, %d/%d successful, %v average latency",
+		resolver, stats.demotion, stats.consecutive, stats.successes, stats.successes+stats.failures, stats.latency)
+}
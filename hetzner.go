@@ -0,0 +1,178 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// hetznerEndpoint is the base URL of the Hetzner DNS API.
+const hetznerEndpoint = "https://dns.hetzner.com/api/v1"
+
+// hetznerProvider is the Hetzner DNS provider, updating the records of zones
+// through the REST API, authenticated with an API token.
+type hetznerProvider struct {
+	client *http.Client // HTTP client to execute the API calls with
+	token  string       // DNS API token of the account
+}
+
+// newHetznerProvider creates a Hetzner DNS provider with the given API token,
+// falling back to the environment if none was specified.
+func newHetznerProvider(client *http.Client, token string) (*hetznerProvider, error) {
+	if token == "" {
+		token = os.Getenv("HETZNER_DNS_TOKEN")
+	}
+	if token == "" {
+		return nil, errors.New("no API token specified, use -hetzner-token")
+	}
+	return &hetznerProvider{client: client, token: token}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *hetznerProvider) String() string {
+	return "hetzner"
+}
+
+// hetznerRecord is a DNS record as represented by the Hetzner DNS API.
+type hetznerRecord struct {
+	ID     string `json:"id,omitempty"`
+	ZoneID string `json:"zone_id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	TTL    int    `json:"ttl,omitempty"`
+}
+
+// verify implements provider, checking that the zones of all domains are
+// accessible with the configured token.
+func (p *hetznerProvider) verify(domains []*domain) error {
+	for _, host := range domains {
+		zone, err := p.resolveZone(host)
+		if err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "could not be found") {
+				return fmt.Errorf("zone of %s not accessible: %v", host, err)
+			}
+			logErrorf("Failed to verify zone of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record of a domain to the given
+// address, according to the configured policy for multiple records.
+func (p *hetznerProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = p.resolveZone(host); err != nil {
+			return false, fmt.Errorf("zone id resolution failed: %v", err)
+		}
+	}
+	zone, err := hostZone(host)
+	if err != nil {
+		return false, err
+	}
+	name := relativeName(host.name, zone)
+
+	// The API can only filter by zone, so look up the matching records locally
+	var reply struct {
+		Records []hetznerRecord `json:"records"`
+	}
+	if err := p.call("GET", "/records?zone_id="+url.QueryEscape(host.zoneID), nil, &reply); err != nil {
+		host.zoneID = ""
+		return false, fmt.Errorf("record retrieval failed: %v", err)
+	}
+	var (
+		recs   []hetznerRecord
+		values []string
+	)
+	for _, rec := range reply.Records {
+		if rec.Type == kind && strings.EqualFold(rec.Name, name) {
+			recs, values = append(recs, rec), append(values, rec.Value)
+		}
+	}
+	if len(recs) == 0 && !*createFlag {
+		return false, fmt.Errorf("invalid number of DNS records found: %+v", recs)
+	}
+	index, stale, err := pickValue(values, address, previous)
+	if err != nil {
+		return false, err
+	}
+	// Assemble the new record, leaving automatic TTLs to the zone default
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl == 1 {
+		ttl = 0
+	}
+	update := hetznerRecord{ZoneID: host.zoneID, Type: kind, Name: name, Value: address, TTL: ttl}
+
+	changed := len(stale) > 0
+	switch {
+	case index < 0:
+		if err := p.call("POST", "/records", update, nil); err != nil {
+			return false, fmt.Errorf("dns record creation failed: %v", err)
+		}
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+		changed = true
+
+	case recs[index].Value != address || recs[index].TTL != ttl:
+		if err := p.call("PUT", "/records/"+url.PathEscape(recs[index].ID), update, nil); err != nil {
+			return false, fmt.Errorf("dns record update failed: %v", err)
+		}
+		changed = true
+	}
+	// Delete the records made superfluous by the multiple records policy
+	for _, i := range stale {
+		if err := p.call("DELETE", "/records/"+url.PathEscape(recs[i].ID), nil, nil); err != nil {
+			logErrorf("Failed to delete superfluous record of %s (%s): %v", host, values[i], err)
+			continue
+		}
+		logInfof("Deleted superfluous record of %s (%s)", host, values[i])
+	}
+	return changed, nil
+}
+
+// resolveZone retrieves the Hetzner zone ID of a domain, either from its explicit
+// zone setting or by deriving the zone from the domain name.
+func (p *hetznerProvider) resolveZone(host *domain) (string, error) {
+	zone, err := hostZone(host)
+	if err != nil {
+		return "", err
+	}
+	var reply struct {
+		Zones []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"zones"`
+	}
+	if err := p.call("GET", "/zones?name="+url.QueryEscape(zone), nil, &reply); err != nil {
+		if strings.Contains(err.Error(), "HTTP status 404") {
+			return "", fmt.Errorf("zone %s could not be found", zone)
+		}
+		return "", err
+	}
+	for _, z := range reply.Zones {
+		if normalizeName(z.Name) == zone {
+			return z.ID, nil
+		}
+	}
+	return "", fmt.Errorf("zone %s could not be found", zone)
+}
+
+// call executes an authorized Hetzner DNS API request.
+func (p *hetznerProvider) call(method string, path string, payload interface{}, result interface{}) error {
+	if simulated(method, hetznerEndpoint+path, payload) {
+		return nil
+	}
+	return jsonCall(p.client, method, hetznerEndpoint+path, http.Header{"Auth-API-Token": {p.token}}, payload, result)
+}
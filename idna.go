@@ -0,0 +1,145 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// toASCII converts an internationalized domain name into its ASCII form as used
+// by CloudFlare, encoding every non-ASCII label into punycode (A-label). Names
+// are expected to be lowercase already; Unicode normalization is not performed.
+func toASCII(name string) (string, error) {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		ascii := true
+		for j := 0; j < len(label); j++ {
+			if label[j] >= utf8.RuneSelf {
+				ascii = false
+				break
+			}
+		}
+		if ascii {
+			continue
+		}
+		if !utf8.ValidString(label) {
+			return "", fmt.Errorf("invalid UTF-8 in label %q", label)
+		}
+		encoded, err := punycode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = "xn--" + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// Bootstring parameters of punycode, as defined in RFC 3492.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycode encodes a single Unicode label via the punycode algorithm defined in
+// RFC 3492, section 6.3.
+func punycode(label string) (string, error) {
+	var (
+		runes  = []rune(label)
+		output []byte
+	)
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			output = append(output, byte(r))
+		}
+	}
+	basic := len(output)
+	if basic > 0 {
+		output = append(output, '-')
+	}
+	var (
+		n       = rune(punyInitialN)
+		delta   = 0
+		bias    = punyInitialBias
+		handled = basic
+	)
+	for handled < len(runes) {
+		// Find the smallest code point not yet handled
+		next := rune(0x7fffffff)
+		for _, r := range runes {
+			if r >= n && r < next {
+				next = r
+			}
+		}
+		if int(next-n) > (0x7fffffff-delta)/(handled+1) {
+			return "", fmt.Errorf("punycode overflow in label %q", label)
+		}
+		delta += int(next-n) * (handled + 1)
+		n = next
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			// Encode the delta as a variable length integer
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+				if q < t {
+					break
+				}
+				output = append(output, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			output = append(output, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(output), nil
+}
+
+// punyDigit converts a punycode digit value into its character representation.
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// punyAdapt is the bias adaptation function of punycode.
+func punyAdapt(delta int, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,46 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"testing"
+)
+
+// Tests that internationalized domain names are converted into their ASCII form.
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		fail bool
+	}{
+		{name: "www.example.com", want: "www.example.com"},
+		{name: "xn--bcher-kva.example.com", want: "xn--bcher-kva.example.com"},
+		{name: "bücher.example.com", want: "xn--bcher-kva.example.com"},
+		{name: "münchen.de", want: "xn--mnchen-3ya.de"},
+		{name: "例え.テスト", want: "xn--r8jz45g.xn--zckzah"},
+		{name: "пример.рф", want: "xn--e1afmkfd.xn--p1ai"},
+		{name: "*.bücher.example.com", want: "*.xn--bcher-kva.example.com"},
+		{name: "ü", want: "xn--tda"},
+		// Samples of RFC 3492, section 7.1 (A, B, L and M)
+		{name: "ليهمابتكلموشعربي؟", want: "xn--egbpdaj6bu4bxfgehfvwxn"},
+		{name: "他们为什么不说中文", want: "xn--ihqwcrb4cv8a8dqg056pqjye"},
+		{name: "3年b組金八先生", want: "xn--3b-ww4c5e180e575a65lsy2b"},
+		{name: "安室奈美恵-with-super-monkeys", want: "xn---with-super-monkeys-pc58ag80a8qai00g7n9n"},
+		{name: "bad\xffname.com", fail: true},
+	}
+	for _, tt := range tests {
+		ascii, err := toASCII(tt.name)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected failure, got %q", tt.name, ascii)
+			}
+			continue
+		}
+		if err != nil || ascii != tt.want {
+			t.Errorf("%q: conversion mismatch: have %q/%v, want %q", tt.name, ascii, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// keyringService is the service name the secrets of the updater are stored under
+// in the OS credential store, with the entry names as account names.
+const keyringService = "cloudflare-dyndns"
+
+// keyringSource returns a token source loading the secret of an entry from the
+// OS credential store (macOS Keychain, Windows Credential Manager or the Secret
+// Service on Linux and BSDs).
+func keyringSource(entry string) func() (string, error) {
+	return func() (string, error) {
+		secret, err := keyringLoad(entry)
+		if err != nil {
+			return "", fmt.Errorf("keyring entry %s: %v", entry, err)
+		}
+		return secret, nil
+	}
+}
+
+// runKeyring stores the CloudFlare API token (given via -token or entered when
+// asked for) into the OS credential store, under the entry configured via the
+// -token-keyring flag.
+func runKeyring(in io.Reader, out io.Writer) error {
+	if *tokenKeyring == "" {
+		return errors.New("no keyring entry specified, use -token-keyring")
+	}
+	token := *tokenFlag
+	if token == "" {
+		var err error
+		if token, err = prompt(bufio.NewReader(in), out, "API token", ""); err != nil {
+			return err
+		}
+		if token == "" {
+			return errors.New("no API token given")
+		}
+	}
+	if err := keyringStore(*tokenKeyring, token); err != nil {
+		return fmt.Errorf("keyring entry %s: %v", *tokenKeyring, err)
+	}
+	fmt.Fprintf(out, "Stored API token in keyring entry %s\n", *tokenKeyring)
+	return nil
+}
@@ -0,0 +1,51 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringLoad retrieves a secret from the macOS Keychain via the security tool,
+// stored as a generic password of the updater's service.
+func keyringLoad(entry string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", entry, "-w")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// keyringStore saves a secret into the macOS Keychain via the security tool,
+// replacing any previous one of the same entry. The command is fed to the tool's
+// interactive mode on the standard input, keeping the secret out of the process
+// list instead of passing it as an argument.
+func keyringStore(entry string, secret string) error {
+	if strings.ContainsAny(secret, "\"\\\r\n") {
+		return errors.New("secret contains quotes, backslashes or newlines")
+	}
+	command := fmt.Sprintf("add-generic-password -U -l %q -s %q -a %q -w \"%s\"\n", keyringService+" "+entry, keyringService, entry, secret)
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = strings.NewReader(command), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// Interactive mode exits cleanly even if a command failed, check its output
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
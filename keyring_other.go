@@ -0,0 +1,47 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringLoad retrieves a secret from the Secret Service (GNOME Keyring, KWallet,
+// KeePassXC) via the secret-tool utility, looked up by the updater's service and
+// the entry name attributes.
+func keyringLoad(entry string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("secret-tool", "lookup", "service", keyringService, "account", entry)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", fmt.Errorf("%v (entry not found?)", err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// keyringStore saves a secret into the Secret Service via the secret-tool utility,
+// replacing any previous one of the same entry. The secret is passed on the
+// standard input, keeping it out of the process list.
+func keyringStore(entry string, secret string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("secret-tool", "store", "--label="+keyringService+" "+entry, "service", keyringService, "account", entry)
+	cmd.Stdin, cmd.Stderr = strings.NewReader(secret), &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Generic credential settings of the Windows Credential Manager.
+const (
+	credTypeGeneric         = 1 // CRED_TYPE_GENERIC
+	credPersistLocalMachine = 2 // CRED_PERSIST_LOCAL_MACHINE
+)
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead  = advapi32.NewProc("CredReadW")
+	procCredWrite = advapi32.NewProc("CredWriteW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+// winCredential is the CREDENTIALW structure of the Windows Credential Manager.
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keyringTarget returns the Credential Manager target name of an entry.
+func keyringTarget(entry string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(keyringService + ":" + entry)
+}
+
+// keyringLoad retrieves a secret from the Windows Credential Manager, stored as
+// a generic credential named after the updater's service and the entry.
+func keyringLoad(entry string) (string, error) {
+	target, err := keyringTarget(entry)
+	if err != nil {
+		return "", err
+	}
+	var cred *winCredential
+	if res, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); res == 0 {
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+// keyringStore saves a secret into the Windows Credential Manager, replacing any
+// previous one of the same entry.
+func keyringStore(entry string, secret string) error {
+	target, err := keyringTarget(entry)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(entry)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	cred := &winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	if res, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(cred)), 0); res == 0 {
+		return err
+	}
+	return nil
+}
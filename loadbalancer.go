@@ -0,0 +1,81 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// lbOrigin is a named origin within a CloudFlare Load Balancer pool, whose
+// address is kept in sync with the external address of the default uplink.
+type lbOrigin struct {
+	pool   string          // Identifier of the load balancer pool
+	origin string          // Name of the origin within the pool
+	api    *cloudflare.API // CloudFlare client to update the pool with
+}
+
+// String implements fmt.Stringer, returning a descriptive name of the origin.
+func (o *lbOrigin) String() string {
+	return "load balancer origin " + o.pool + "/" + o.origin
+}
+
+// parseOrigins parses a list of load balancer origins in pool/origin form.
+func parseOrigins(specs []string) ([]*lbOrigin, error) {
+	var origins []*lbOrigin
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid load balancer origin, expected pool/origin: %s", spec)
+		}
+		origins = append(origins, &lbOrigin{pool: parts[0], origin: parts[1]})
+	}
+	return origins, nil
+}
+
+// update implements target, updating the address of the load balancer origin,
+// provided that its current address is of the same family.
+func (o *lbOrigin) update(address string, ipv6 bool) (bool, error) {
+	// Retrieve the current origins of the pool, retaining all unknown fields
+	endpoint := "/accounts/" + *accountIDFlag + "/load_balancers/pools/" + o.pool
+
+	res, err := callAPI(o.api, "GET", endpoint, nil)
+	if err != nil {
+		return false, fmt.Errorf("pool retrieval failed: %v", err)
+	}
+	var pool struct {
+		Origins []map[string]interface{} `json:"origins"`
+	}
+	if err := json.Unmarshal(res, &pool); err != nil {
+		return false, fmt.Errorf("invalid pool: %v", err)
+	}
+	// Locate the origin to update and swap out its address
+	var found bool
+	for _, origin := range pool.Origins {
+		if name, _ := origin["name"].(string); name != o.origin {
+			continue
+		}
+		current, _ := origin["address"].(string)
+		if ip := net.ParseIP(current); ip == nil || (ip.To4() == nil) != ipv6 {
+			return false, nil
+		}
+		if current == address {
+			return true, nil
+		}
+		origin["address"], found = address, true
+	}
+	if !found {
+		return false, fmt.Errorf("origin not found in pool")
+	}
+	if _, err := callAPI(o.api, "PATCH", endpoint, pool); err != nil {
+		return false, fmt.Errorf("pool update failed: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,157 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// queryLocal retrieves an IP address assigned to one of the machine's own network
+// interfaces, for publishing LAN or VPN addresses in split-horizon setups. The
+// candidates may be restricted to a single interface and/or to a subnet. Link
+// local addresses are never published.
+func queryLocal(name string, subnet string, ipv6 bool) (string, error) {
+	var network *net.IPNet
+	if subnet != "" {
+		var err error
+		if _, network, err = net.ParseCIDR(subnet); err != nil {
+			return "", err
+		}
+	}
+	// Gather the interfaces to pick the address from
+	var ifaces []net.Interface
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return "", err
+		}
+		ifaces = append(ifaces, *iface)
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return "", err
+		}
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+	// Gather all the addresses matching the requirements
+	var candidates []net.IP
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipnet.IP
+			if (ip.To4() == nil) != ipv6 || ip.IsLinkLocalUnicast() || ip.IsLoopback() {
+				continue
+			}
+			if network != nil && !network.Contains(ip) {
+				continue
+			}
+			candidates = append(candidates, ip)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", errors.New("no matching local address found")
+	}
+	if ipv6 {
+		return selectIPv6(candidates, *policyFlag).String(), nil
+	}
+	return candidates[0].String(), nil
+}
+
+// Address flags of the Linux kernel, as reported in /proc/net/if_inet6.
+const (
+	ifaFlagTemporary  = 0x01 // Temporary (privacy extension) address
+	ifaFlagDeprecated = 0x20 // Address past its preferred lifetime
+	ifaFlagTentative  = 0x40 // Address still undergoing duplicate detection
+	ifaFlagPermanent  = 0x80 // Manually configured (non-autoconfigured) address
+)
+
+// selectIPv6 picks the IPv6 address to publish from a set of candidates based on
+// the selection policy, avoiding rotating privacy addresses so the AAAA records
+// don't churn needlessly:
+//
+//   - stable: manually configured > EUI-64 > other stable > temporary
+//   - eui64:  EUI-64 > manually configured > other stable > temporary
+//   - any:    first address found
+//
+// Deprecated and tentative addresses are always picked last. The address flags
+// are only available on Linux, elsewhere EUI-64 detection is all that's done.
+func selectIPv6(candidates []net.IP, policy string) net.IP {
+	if policy == "any" {
+		return candidates[0]
+	}
+	flags := ipv6Flags()
+
+	best, score := candidates[0], -1
+	for _, ip := range candidates {
+		var (
+			flag  = flags[ip.String()]
+			eui64 = ip[11] == 0xff && ip[12] == 0xfe
+			rank  int
+		)
+		switch {
+		case flag&(ifaFlagDeprecated|ifaFlagTentative) != 0:
+			rank = 0
+		case flag&ifaFlagTemporary != 0:
+			rank = 1
+		case eui64 && policy == "eui64":
+			rank = 5
+		case flag&ifaFlagPermanent != 0:
+			rank = 4
+		case eui64:
+			rank = 3
+		default:
+			rank = 2
+		}
+		if rank > score {
+			best, score = ip, rank
+		}
+	}
+	return best
+}
+
+// ipv6Flags retrieves the kernel flags of all the local IPv6 addresses from the
+// Linux /proc/net/if_inet6 table. On other platforms an empty set is returned.
+func ipv6Flags() map[string]uint64 {
+	flags := make(map[string]uint64)
+
+	table, err := ioutil.ReadFile("/proc/net/if_inet6")
+	if err != nil {
+		return flags
+	}
+	for _, line := range strings.Split(string(table), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || len(fields[0]) != 32 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil {
+			continue
+		}
+		flag, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		flags[net.IP(raw).String()] = flag
+	}
+	return flags
+}
@@ -0,0 +1,169 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rotatedSuffix is the time format of the suffix appended to rotated log files.
+const rotatedSuffix = "20060102-150405.000"
+
+// rotatingFile is a log file rotated once it grows beyond a size limit or gets
+// older than an age limit, retaining only a number of the rotated files.
+type rotatingFile struct {
+	path    string        // Path of the active log file
+	maxSize int64         // Size in bytes after which to rotate (0 = unlimited)
+	maxAge  time.Duration // Age after which to rotate (0 = unlimited)
+	retain  int           // Number of rotated files to keep (0 = keep all)
+
+	file   *os.File  // Active log file being appended to
+	size   int64     // Current size of the active log file
+	opened time.Time // Time when the active log file was started
+}
+
+// openRotatingFile opens (or creates) a log file for appending, rotating it when
+// the size or age limits are exceeded.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, retain int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		retain:  retain,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the active log file, picking up the size of any existing contents.
+// The age of existing contents is taken from the time of the last rotation (or
+// from the modification time of the file if it was never rotated), so restarts
+// don't postpone the age based rotation.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	if f.size > 0 {
+		f.opened = info.ModTime()
+		if rotated, err := f.rotated(); err == nil && len(rotated) > 0 {
+			last := strings.TrimPrefix(rotated[len(rotated)-1], f.path+".")
+			if stamp, err := time.ParseInLocation(rotatedSuffix, last, time.Local); err == nil {
+				f.opened = stamp
+			}
+		}
+	}
+	return nil
+}
+
+// rotated returns the rotated log files, oldest first.
+func (f *rotatingFile) rotated() ([]string, error) {
+	rotated, err := filepath.Glob(f.path + ".[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rotated) // Timestamp suffixes sort chronologically
+	return rotated, nil
+}
+
+// Write implements io.Writer, appending to the active log file and rotating it
+// beforehand if the write would exceed its limits. If the rotation fails, the
+// data is still appended to the active log file, but the failure is reported.
+// Should even that be lost, reopening it is retried on every write.
+func (f *rotatingFile) Write(data []byte) (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	var failure error
+	if f.size > 0 {
+		if (f.maxSize > 0 && f.size+int64(len(data)) > f.maxSize) || (f.maxAge > 0 && time.Since(f.opened) >= f.maxAge) {
+			if failure = f.rotate(); failure != nil && f.file == nil {
+				return 0, failure
+			}
+		}
+	}
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if failure != nil {
+		return n, fmt.Errorf("log rotation failed: %v", failure)
+	}
+	return n, nil
+}
+
+// rotate moves the active log file aside with a timestamp suffix, starts a new
+// one and deletes the oldest rotated files beyond the retention limit. If the
+// file cannot be moved aside or the new one cannot be created, the original one
+// is reopened, so logging can go on.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		f.file = nil
+		if reopen := f.open(); reopen != nil {
+			return reopen
+		}
+		return err
+	}
+	f.file = nil
+
+	rotated := f.path + "." + time.Now().Format(rotatedSuffix)
+	if err := os.Rename(f.path, rotated); err != nil {
+		if reopen := f.open(); reopen != nil {
+			return reopen
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		if os.Rename(rotated, f.path) == nil {
+			if reopen := f.open(); reopen != nil {
+				return reopen
+			}
+		}
+		return err
+	}
+	if f.retain <= 0 {
+		return nil
+	}
+	files, err := f.rotated()
+	if err != nil {
+		return err
+	}
+	for len(files) > f.retain {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// fileSink returns a log sink appending the events to a rotating log file. Plain
+// text events are prefixed with the time, JSON ones carry it already.
+func fileSink(file *rotatingFile, structured bool) logSink {
+	return func(level logLevel, line string) error {
+		if !structured {
+			line = time.Now().Format("2006/01/02 15:04:05 ") + line
+		}
+		_, err := file.Write([]byte(line + "\n"))
+		return err
+	}
+}
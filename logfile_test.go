@@ -0,0 +1,157 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLogs returns the contents of the active and the rotated log files, oldest
+// rotated one first.
+func readLogs(t *testing.T, f *rotatingFile) (string, []string) {
+	t.Helper()
+
+	active, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		t.Fatalf("failed to read active log: %v", err)
+	}
+	files, err := f.rotated()
+	if err != nil {
+		t.Fatalf("failed to list rotated logs: %v", err)
+	}
+	var rotated []string
+	for _, file := range files {
+		blob, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read rotated log: %v", err)
+		}
+		rotated = append(rotated, string(blob))
+	}
+	return string(active), rotated
+}
+
+// Tests that log files are rotated by size, retaining only the configured number
+// of rotated files.
+func TestRotatingFileSize(t *testing.T) {
+	tests := []struct {
+		maxSize int64
+		retain  int
+		writes  []string
+		active  string
+		rotated []string
+	}{
+		// No limits, never rotated
+		{maxSize: 0, writes: []string{"aaaa", "bbbb", "cccc"}, active: "aaaabbbbcccc"},
+		// Rotated once the limit would be exceeded
+		{maxSize: 8, writes: []string{"aaaa", "bbbb", "cccc"}, active: "cccc", rotated: []string{"aaaabbbb"}},
+		// Oversized writes are not split, only rotated before
+		{maxSize: 2, writes: []string{"aaaa", "bbbb"}, active: "bbbb", rotated: []string{"aaaa"}},
+		// Only the newest rotated files are retained
+		{maxSize: 4, retain: 2, writes: []string{"aaaa", "bbbb", "cccc", "dddd"}, active: "dddd", rotated: []string{"bbbb", "cccc"}},
+		{maxSize: 4, retain: 0, writes: []string{"aaaa", "bbbb", "cccc", "dddd"}, active: "dddd", rotated: []string{"aaaa", "bbbb", "cccc"}},
+	}
+	for i, tt := range tests {
+		f, err := openRotatingFile(filepath.Join(t.TempDir(), "updater.log"), tt.maxSize, 0, tt.retain)
+		if err != nil {
+			t.Fatalf("test %d: failed to open log: %v", i, err)
+		}
+		for _, data := range tt.writes {
+			time.Sleep(2 * time.Millisecond) // Ensure distinct rotation suffixes
+			if _, err := f.Write([]byte(data)); err != nil {
+				t.Fatalf("test %d: failed to write log: %v", i, err)
+			}
+		}
+		active, rotated := readLogs(t, f)
+		if active != tt.active || strings.Join(rotated, ",") != strings.Join(tt.rotated, ",") {
+			t.Errorf("test %d: logs mismatch: have %q/%q, want %q/%q", i, active, rotated, tt.active, tt.rotated)
+		}
+		f.file.Close()
+	}
+}
+
+// Tests that the age of existing log files is picked up on startup, instead of
+// restarts postponing the age based rotation.
+func TestRotatingFileAge(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		modified time.Duration // Age of the active log file's modification time
+		rotated  time.Duration // Age of the last rotation (0 = never rotated)
+		rotate   bool
+	}{
+		{name: "new file", contents: "", modified: 2 * time.Hour, rotate: false},
+		{name: "fresh file", contents: "old\n", modified: time.Minute, rotate: false},
+		{name: "stale file", contents: "old\n", modified: 2 * time.Hour, rotate: true},
+		{name: "recently rotated", contents: "old\n", modified: time.Minute, rotated: 10 * time.Minute, rotate: false},
+		{name: "rotated long ago", contents: "old\n", modified: time.Minute, rotated: 2 * time.Hour, rotate: true},
+	}
+	for _, tt := range tests {
+		var (
+			dir  = t.TempDir()
+			path = filepath.Join(dir, "updater.log")
+		)
+		if err := ioutil.WriteFile(path, []byte(tt.contents), 0640); err != nil {
+			t.Fatalf("%s: failed to create log: %v", tt.name, err)
+		}
+		stamp := time.Now().Add(-tt.modified)
+		if err := os.Chtimes(path, stamp, stamp); err != nil {
+			t.Fatalf("%s: failed to age log: %v", tt.name, err)
+		}
+		if tt.rotated > 0 {
+			old := path + "." + time.Now().Add(-tt.rotated).Format(rotatedSuffix)
+			if err := ioutil.WriteFile(old, []byte("older\n"), 0640); err != nil {
+				t.Fatalf("%s: failed to create rotated log: %v", tt.name, err)
+			}
+		}
+		f, err := openRotatingFile(path, 0, time.Hour, 0)
+		if err != nil {
+			t.Fatalf("%s: failed to open log: %v", tt.name, err)
+		}
+		if _, err := f.Write([]byte("new\n")); err != nil {
+			t.Fatalf("%s: failed to write log: %v", tt.name, err)
+		}
+		active, _ := readLogs(t, f)
+		if rotated := active == "new\n" && tt.contents != ""; rotated != tt.rotate {
+			t.Errorf("%s: rotation mismatch: have %v, want %v (active %q)", tt.name, rotated, tt.rotate, active)
+		}
+		f.file.Close()
+	}
+}
+
+// Tests that a failed rotation does not lose the log file.
+func TestRotatingFileFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updater.log")
+
+	f, err := openRotatingFile(path, 4, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	if _, err := f.Write([]byte("aaaa")); err != nil {
+		t.Fatalf("failed to write log: %v", err)
+	}
+	// Remove the active log from beneath the writer, failing the rename
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("failed to remove log: %v", err)
+	}
+	if n, err := f.Write([]byte("bbbb")); err == nil || n != 4 {
+		t.Errorf("failed rotation mismatch: have %d/%v, want 4/error", n, err)
+	}
+	// Ensure logging goes on and rotation recovers afterwards
+	time.Sleep(2 * time.Millisecond)
+	if _, err := f.Write([]byte("cccc")); err != nil {
+		t.Fatalf("failed to write log after failed rotation: %v", err)
+	}
+	active, rotated := readLogs(t, f)
+	if active != "cccc" || strings.Join(rotated, ",") != "bbbb" {
+		t.Errorf("logs mismatch: have %q/%q, want %q/%q", active, rotated, "cccc", []string{"bbbb"})
+	}
+	f.file.Close()
+}
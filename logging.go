@@ -0,0 +1,250 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a log event.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota // Troubleshooting details (e.g. resolver replies, API calls)
+	levelInfo                  // Regular operation (e.g. updated records)
+	levelWarn                  // Suspicious conditions not preventing updates
+	levelError                 // Failed resolutions, updates or other operations
+)
+
+// logLevelNames are the names of the log levels, as accepted by -log-level.
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// String implements fmt.Stringer, returning the name of the log level.
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// minLogLevel is the lowest severity of the events logged.
+var minLogLevel = levelInfo
+
+// logFields are the structured fields attached to a log event, queryable by log
+// aggregators (e.g. Loki or ELK) when logging in JSON format.
+type logFields map[string]interface{}
+
+// logSink is a destination of the formatted log events (e.g. syslog).
+type logSink func(level logLevel, line string) error
+
+// eventLogger formats the log events and writes them to a sink, replacing the
+// plain text output of the standard logger to stderr.
+type eventLogger struct {
+	json bool       // Whether to format the events as JSON objects
+	sink logSink    // Destination to write the formatted events to
+	lock sync.Mutex // Lock serializing the writes of concurrent events
+}
+
+// events is the event logger if enabled, nil when logging plain text to stderr.
+var events *eventLogger
+
+// Write implements io.Writer, emitting the lines of the standard logger without
+// any structured fields. The standard logger is only used for fatal errors, all
+// other events going through the leveled helpers.
+func (l *eventLogger) Write(line []byte) (int, error) {
+	if err := l.emit(levelError, strings.TrimSuffix(string(line), "\n"), nil); err != nil {
+		return 0, err
+	}
+	return len(line), nil
+}
+
+// emit formats a message along with its structured fields and writes it to the
+// sink. In JSON format the event also carries its time and level.
+func (l *eventLogger) emit(level logLevel, msg string, fields logFields) error {
+	line := msg
+	if l.json {
+		event := make(map[string]interface{}, len(fields)+3)
+		for key, value := range fields {
+			event[key] = value
+		}
+		event["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+		event["level"] = level.String()
+		event["msg"] = msg
+
+		blob, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		line = string(blob)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.sink(level, line)
+}
+
+// stderrSink writes the log events to the standard error, one per line.
+func stderrSink(level logLevel, line string) error {
+	_, err := io.WriteString(os.Stderr, line+"\n")
+	return err
+}
+
+// setupLogging configures the log output according to the selected format, the
+// destination and the minimum level of the events to log.
+func setupLogging() error {
+	level := -1
+	for i, name := range logLevelNames {
+		if name == *logLevelFlag {
+			level = i
+		}
+	}
+	if level < 0 {
+		return fmt.Errorf("unknown log level: %s", *logLevelFlag)
+	}
+	minLogLevel = logLevel(level)
+
+	var structured bool
+	switch *logFormatFlag {
+	case "text":
+	case "json":
+		structured = true
+	default:
+		return fmt.Errorf("unknown log format: %s", *logFormatFlag)
+	}
+	var sink logSink
+	switch {
+	case *syslogFlag != "" && *logFileFlag != "":
+		return errors.New("both -syslog and -log-file specified")
+
+	case *syslogFlag != "":
+		var err error
+		if sink, err = syslogSink(*syslogFlag, *syslogFacilityFlag); err != nil {
+			return fmt.Errorf("failed to connect to syslog: %v", err)
+		}
+	case *logFileFlag != "":
+		file, err := openRotatingFile(*logFileFlag, int64(*logMaxSizeFlag)<<20, *logMaxAgeFlag, *logRetainFlag)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		sink = fileSink(file, structured)
+
+	case *serviceFlag == "run":
+		var err error
+		if sink, err = eventlogSink(); err != nil {
+			return fmt.Errorf("failed to open event log: %v", err)
+		}
+	}
+	// Plain text to stderr is what the standard logger does, keep it for that
+	if !structured && sink == nil {
+		return nil
+	}
+	if sink == nil {
+		sink = stderrSink
+	}
+	events = &eventLogger{json: structured, sink: sink}
+	log.SetFlags(0)
+	log.SetOutput(events)
+	return nil
+}
+
+// logEnabled reports whether events of the given level are logged.
+func logEnabled(level logLevel) bool {
+	return level >= minLogLevel
+}
+
+// logEvent logs a message of the given level along with structured fields. The
+// fields are emitted only in JSON format, plain text logs carry the message alone.
+func logEvent(level logLevel, fields logFields, format string, args ...interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if events == nil {
+		log.Print(msg)
+		return
+	}
+	if err := events.emit(level, msg, fields); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to log event: %v\n", err)
+	}
+}
+
+// logDebugf logs a troubleshooting event, only emitted if debug logging is on.
+func logDebugf(format string, args ...interface{}) {
+	logEvent(levelDebug, nil, format, args...)
+}
+
+// logInfof logs an event of the regular operation.
+func logInfof(format string, args ...interface{}) {
+	logEvent(levelInfo, nil, format, args...)
+}
+
+// logWarnf logs a suspicious condition not preventing the updates.
+func logWarnf(format string, args ...interface{}) {
+	logEvent(levelWarn, nil, format, args...)
+}
+
+// logErrorf logs a failed operation.
+func logErrorf(format string, args ...interface{}) {
+	logEvent(levelError, nil, format, args...)
+}
+
+// sensitiveQueryKeys are the URL query parameters redacted from debug logs, as
+// some services (e.g. DynDNS2 or push receivers) carry credentials in them.
+var sensitiveQueryKeys = []string{"token", "password", "pass", "pwd", "secret", "key", "auth", "signature", "credential"}
+
+// redactURL hides the credentials embedded into a URL, in the userinfo or in
+// the query parameters, for logging it.
+func redactURL(u *url.URL) string {
+	safe := *u
+	if safe.User != nil {
+		if _, ok := safe.User.Password(); ok {
+			safe.User = url.UserPassword(safe.User.Username(), "redacted")
+		}
+	}
+	if safe.RawQuery != "" {
+		query := safe.Query()
+		for key := range query {
+			lower := strings.ToLower(key)
+			for _, sensitive := range sensitiveQueryKeys {
+				if strings.Contains(lower, sensitive) {
+					query.Set(key, "redacted")
+					break
+				}
+			}
+		}
+		safe.RawQuery = query.Encode()
+	}
+	return safe.String()
+}
+
+// recordFields assembles the structured fields of a record update, omitting the
+// ones not known.
+func recordFields(host *domain, kind string, previous string, content string, elapsed time.Duration, err error) logFields {
+	fields := logFields{
+		"domain": host.String(),
+		"type":   kind,
+		"new_ip": content,
+	}
+	if zone, err := hostZone(host); err == nil {
+		fields["zone"] = zone
+	}
+	if previous != "" {
+		fields["old_ip"] = previous
+	}
+	if elapsed > 0 {
+		fields["duration"] = elapsed.Seconds()
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	return fields
+}
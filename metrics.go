@@ -0,0 +1,106 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsPrefix is the namespace of all the exported Prometheus metrics.
+const metricsPrefix = "cloudflare_dyndns_"
+
+// writeMetrics writes the status of the updater and the statistics of the
+// resolvers in the Prometheus text exposition format.
+func writeMetrics(w io.Writer) {
+	healthy, families, domains := status.report()
+
+	metricHeader(w, "healthy", "gauge", "Whether all addresses were resolved recently and all records updated (1) or not (0)")
+	fmt.Fprintf(w, "%shealthy %d\n", metricsPrefix, metricBool(healthy))
+
+	// Export the address family metrics
+	metricHeader(w, "last_resolution_timestamp_seconds", "gauge", "Time of the last successful address resolution")
+	for _, stat := range families {
+		if stat.Resolved != nil {
+			fmt.Fprintf(w, "%slast_resolution_timestamp_seconds{family=%s} %d\n", metricsPrefix, metricLabel(stat.Family), stat.Resolved.Unix())
+		}
+	}
+	metricHeader(w, "resolution_failing", "gauge", "Whether the last address resolution failed (1) or not (0)")
+	for _, stat := range families {
+		fmt.Fprintf(w, "%sresolution_failing{family=%s} %d\n", metricsPrefix, metricLabel(stat.Family), metricBool(stat.Error != ""))
+	}
+	metricHeader(w, "address_changes_total", "counter", "Number of times the resolved external address changed")
+	for _, stat := range families {
+		fmt.Fprintf(w, "%saddress_changes_total{family=%s} %d\n", metricsPrefix, metricLabel(stat.Family), stat.Changes)
+	}
+	// Export the domain record metrics
+	metricHeader(w, "last_update_timestamp_seconds", "gauge", "Time of the last successful update of a record")
+	for _, stat := range domains {
+		if stat.Updated != nil {
+			fmt.Fprintf(w, "%slast_update_timestamp_seconds{domain=%s,type=%s} %d\n", metricsPrefix, metricLabel(stat.Domain), metricLabel(stat.Type), stat.Updated.Unix())
+		}
+	}
+	metricHeader(w, "updates_total", "counter", "Number of record updates by outcome")
+	for _, stat := range domains {
+		fmt.Fprintf(w, "%supdates_total{domain=%s,type=%s,result=\"success\"} %d\n", metricsPrefix, metricLabel(stat.Domain), metricLabel(stat.Type), stat.Successes)
+		fmt.Fprintf(w, "%supdates_total{domain=%s,type=%s,result=\"failure\"} %d\n", metricsPrefix, metricLabel(stat.Domain), metricLabel(stat.Type), stat.Failures)
+	}
+	// Export the resolver metrics, in a stable order
+	stats := health.snapshot()
+
+	resolvers := make([]string, 0, len(stats))
+	for resolver := range stats {
+		resolvers = append(resolvers, resolver)
+	}
+	sort.Strings(resolvers)
+
+	metricHeader(w, "resolver_queries_total", "counter", "Number of queries of an address resolution service by outcome")
+	for _, resolver := range resolvers {
+		fmt.Fprintf(w, "%sresolver_queries_total{resolver=%s,result=\"success\"} %d\n", metricsPrefix, metricLabel(resolverLabel(resolver)), stats[resolver].successes)
+		fmt.Fprintf(w, "%sresolver_queries_total{resolver=%s,result=\"failure\"} %d\n", metricsPrefix, metricLabel(resolverLabel(resolver)), stats[resolver].failures)
+	}
+	metricHeader(w, "resolver_latency_seconds", "summary", "Latency of the successful queries of an address resolution service")
+	for _, resolver := range resolvers {
+		fmt.Fprintf(w, "%sresolver_latency_seconds_sum{resolver=%s} %s\n", metricsPrefix, metricLabel(resolverLabel(resolver)), strconv.FormatFloat(stats[resolver].elapsed.Seconds(), 'f', -1, 64))
+		fmt.Fprintf(w, "%sresolver_latency_seconds_count{resolver=%s} %d\n", metricsPrefix, metricLabel(resolverLabel(resolver)), stats[resolver].successes)
+	}
+	metricHeader(w, "resolver_demoted", "gauge", "Whether an address resolution service is demoted for failing (1) or not (0)")
+	for _, resolver := range resolvers {
+		fmt.Fprintf(w, "%sresolver_demoted{resolver=%s} %d\n", metricsPrefix, metricLabel(resolverLabel(resolver)), metricBool(time.Now().Before(stats[resolver].demoted)))
+	}
+}
+
+// metricHeader writes the help and type comments of a metric.
+func metricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+// metricLabel quotes and escapes a label value.
+func metricLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// resolverLabel converts the address of a resolution service into a metric label,
+// redacting any credentials embedded into service URLs.
+func resolverLabel(resolver string) string {
+	if u, err := url.Parse(resolver); err == nil && u.Scheme != "" && u.Host != "" {
+		return redactURL(u)
+	}
+	return resolver
+}
+
+// metricBool converts a flag into a gauge value.
+func metricBool(flag bool) int {
+	if flag {
+		return 1
+	}
+	return 0
+}
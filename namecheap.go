@@ -0,0 +1,134 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// namecheapEndpoint is the dynamic DNS update URL of Namecheap.
+const namecheapEndpoint = "https://dynamicdns.park-your-domain.com/update"
+
+// namecheapProvider is the Namecheap dynamic DNS provider, pushing addresses to
+// the update URL of Namecheap hosted domains, authenticated with the dynamic DNS
+// password of each domain (found under its Advanced DNS settings).
+type namecheapProvider struct {
+	client    *http.Client      // HTTP client to execute the updates with
+	fallback  string            // Password to use for domains without an explicit one
+	passwords map[string]string // Dynamic DNS passwords by domain (zone)
+}
+
+// newNamecheapProvider creates a Namecheap dynamic DNS provider with the given
+// passwords, either a single one for all domains or comma separated zone=password
+// pairs, falling back to the environment if none was specified.
+func newNamecheapProvider(client *http.Client, passwords string) (*namecheapProvider, error) {
+	if passwords == "" {
+		passwords = os.Getenv("NAMECHEAP_DDNS_PASSWORD")
+	}
+	if passwords == "" {
+		return nil, errors.New("no dynamic DNS password specified, use -namecheap-password")
+	}
+	p := &namecheapProvider{client: client, passwords: make(map[string]string)}
+	if !strings.Contains(passwords, "=") {
+		p.fallback = passwords
+		return p, nil
+	}
+	for _, pair := range splitList(passwords) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid domain password, expected zone=password: %s", pair)
+		}
+		p.passwords[normalizeName(parts[0])] = parts[1]
+	}
+	return p, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *namecheapProvider) String() string {
+	return "namecheap"
+}
+
+// verify implements provider, ensuring that there is a password for every domain.
+// The update URL has no means to check it without updating the host.
+func (p *namecheapProvider) verify(domains []*domain) error {
+	for _, host := range domains {
+		zone, err := hostZone(host)
+		if err != nil {
+			return err
+		}
+		if _, err := p.password(zone); err != nil {
+			return err
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, pushing the new address of a host to Namecheap.
+// Only A records are supported by the update URL, which also does not report
+// whether anything changed.
+func (p *namecheapProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	if kind != "A" {
+		return false, fmt.Errorf("namecheap dynamic dns does not support %s records", kind)
+	}
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = hostZone(host); err != nil {
+			return false, err
+		}
+	}
+	password, err := p.password(host.zoneID)
+	if err != nil {
+		return false, err
+	}
+	query := url.Values{}
+	query.Set("host", relativeName(host.name, host.zoneID))
+	query.Set("domain", host.zoneID)
+	query.Set("password", password)
+	query.Set("ip", address)
+
+	if *dryRunFlag {
+		logInfof("Dry run, not sending: GET %s host=%s domain=%s ip=%s", namecheapEndpoint, query.Get("host"), host.zoneID, address)
+		return true, nil
+	}
+	req, err := http.NewRequest("GET", namecheapEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := apiCall(p.client, req)
+	if err != nil {
+		return false, fmt.Errorf("dyndns update failed: %v", err)
+	}
+	var reply struct {
+		ErrCount int      `xml:"ErrCount"`
+		Errors   []string `xml:"errors>Err1"`
+		Done     bool     `xml:"Done"`
+	}
+	if err := xml.Unmarshal(res, &reply); err != nil {
+		return false, fmt.Errorf("invalid dyndns reply: %v", err)
+	}
+	if reply.ErrCount > 0 || !reply.Done {
+		return false, fmt.Errorf("dyndns update failed: %s", strings.Join(reply.Errors, ", "))
+	}
+	return true, nil
+}
+
+// password returns the dynamic DNS password of a domain.
+func (p *namecheapProvider) password(zone string) (string, error) {
+	if password, ok := p.passwords[zone]; ok {
+		return password, nil
+	}
+	if p.fallback == "" {
+		return "", fmt.Errorf("no dynamic DNS password for %s", zone)
+	}
+	return p.fallback, nil
+}
@@ -0,0 +1,171 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	natpmpPort     = "5351"                 // Port of the NAT-PMP and PCP services on the gateway
+	natpmpAttempts = 4                      // Number of requests to send before giving up
+	natpmpTimeout  = 250 * time.Millisecond // Initial time to wait for a reply, doubled on every retry
+
+	pcpVersion  = 2    // Protocol version of PCP (NAT-PMP is version 0)
+	pcpOpMap    = 1    // Opcode of a PCP mapping request
+	pcpLifetime = 60   // Lifetime of the temporary mapping used to query the address
+	pcpProtoUDP = 17   // IANA protocol number of UDP, used for the temporary mapping
+	pcpResponse = 0x80 // Flag set in the opcode of PCP responses
+)
+
+// queryNATPMP retrieves the external IP address of the machine by sending a
+// NAT-PMP (RFC 6886) external address request to the local gateway.
+func queryNATPMP() (string, error) {
+	gateway, err := gatewayAddress()
+	if err != nil {
+		return "", err
+	}
+	reply, err := natpmpExchange(gateway, []byte{0, 0}, 12)
+	if err != nil {
+		return "", err
+	}
+	if reply[0] != 0 || reply[1] != 128 {
+		return "", fmt.Errorf("unexpected nat-pmp response: version %d, opcode %d", reply[0], reply[1])
+	}
+	if code := binary.BigEndian.Uint16(reply[2:]); code != 0 {
+		return "", fmt.Errorf("nat-pmp request failed: result code %d", code)
+	}
+	return net.IP(reply[8:12]).String(), nil
+}
+
+// queryPCP retrieves the external IP address of the machine by requesting a short
+// lived PCP (RFC 6887) mapping from the local gateway and reading the assigned
+// external address out of the reply. The mapping is deleted afterwards.
+func queryPCP() (string, error) {
+	gateway, err := gatewayAddress()
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.Dial("udp4", net.JoinHostPort(gateway, natpmpPort))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	local := conn.LocalAddr().(*net.UDPAddr)
+
+	// Assemble a mapping request for our own UDP port
+	request := make([]byte, 60)
+	request[0] = pcpVersion
+	request[1] = pcpOpMap
+	binary.BigEndian.PutUint32(request[4:], pcpLifetime)
+	copy(request[8:24], local.IP.To16())
+	if _, err := rand.Read(request[24:36]); err != nil {
+		return "", err
+	}
+	request[36] = pcpProtoUDP
+	binary.BigEndian.PutUint16(request[40:], uint16(local.Port))
+	copy(request[44:60], net.IPv4zero.To16())
+
+	reply, err := natpmpRoundtrip(conn, request, 60)
+	if err != nil {
+		return "", err
+	}
+	if reply[0] != pcpVersion || reply[1] != pcpResponse|pcpOpMap {
+		return "", fmt.Errorf("unexpected pcp response: version %d, opcode %d", reply[0], reply[1])
+	}
+	if reply[3] != 0 {
+		return "", fmt.Errorf("pcp request failed: result code %d", reply[3])
+	}
+	if !bytes.Equal(reply[24:36], request[24:36]) {
+		return "", errors.New("pcp nonce mismatch")
+	}
+	address := net.IP(append([]byte{}, reply[44:60]...))
+
+	// Delete the temporary mapping, ignoring any failures as it expires anyway
+	binary.BigEndian.PutUint32(request[4:], 0)
+	natpmpRoundtrip(conn, request, 60)
+
+	return address.String(), nil
+}
+
+// natpmpExchange sends a NAT-PMP or PCP request to the gateway, waiting for a
+// reply of at least the given length.
+func natpmpExchange(gateway string, request []byte, length int) ([]byte, error) {
+	conn, err := net.Dial("udp4", net.JoinHostPort(gateway, natpmpPort))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return natpmpRoundtrip(conn, request, length)
+}
+
+// natpmpRoundtrip sends a request over an established connection, retrying with
+// exponential backoff until a reply of at least the given length arrives.
+func natpmpRoundtrip(conn net.Conn, request []byte, length int) ([]byte, error) {
+	var (
+		reply   = make([]byte, 1100)
+		timeout = natpmpTimeout
+		err     error
+	)
+	for i := 0; i < natpmpAttempts; i++ {
+		if _, err = conn.Write(request); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		timeout *= 2
+
+		var n int
+		if n, err = conn.Read(reply); err != nil {
+			continue
+		}
+		if n < length {
+			return nil, fmt.Errorf("short gateway response: %d bytes", n)
+		}
+		return reply[:n], nil
+	}
+	return nil, err
+}
+
+// gatewayAddress returns the address of the local network gateway, either as
+// configured by the user or as detected from the Linux routing table.
+func gatewayAddress() (string, error) {
+	if *gatewayFlag != "" {
+		return *gatewayFlag, nil
+	}
+	routes, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", errors.New("unknown gateway, use -gateway")
+	}
+	defer routes.Close()
+
+	scanner := bufio.NewScanner(routes)
+	for scanner.Scan() {
+		// Look for the default route (destination 0.0.0.0)
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		// Gateway addresses are hex encoded in host (little endian) byte order
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		return net.IPv4(raw[3], raw[2], raw[1], raw[0]).String(), nil
+	}
+	return "", errors.New("no default gateway found, use -gateway")
+}
@@ -0,0 +1,68 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// Netlink multicast groups of the address change notifications, missing from the
+// syscall package.
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// watchAddresses subscribes to the kernel's netlink address change notifications,
+// signalling on the returned channel whenever an interface gains or loses an IP
+// address. Multiple notifications are coalesced until the channel is drained.
+func watchAddresses() (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer syscall.Close(fd)
+
+		buffer := make([]byte, syscall.Getpagesize())
+		for {
+			n, _, err := syscall.Recvfrom(fd, buffer, 0)
+			if err != nil {
+				if err == syscall.EINTR || err == syscall.ENOBUFS {
+					continue
+				}
+				logErrorf("Address change notifications failed: %v", err)
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buffer[:n])
+			if err != nil {
+				continue
+			}
+			for _, msg := range msgs {
+				if msg.Header.Type == syscall.RTM_NEWADDR || msg.Header.Type == syscall.RTM_DELADDR {
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+					break
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
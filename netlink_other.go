@@ -0,0 +1,17 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// watchAddresses is not supported outside of Linux, the updater falls back to
+// polling the external address.
+func watchAddresses() (<-chan struct{}, error) {
+	return nil, errors.New("address change notifications only supported on Linux")
+}
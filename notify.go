@@ -0,0 +1,50 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state update (e.g. READY=1) to systemd if the updater was
+// started as a Type=notify service, doing nothing otherwise. Failures are only
+// logged, the service manager being unreachable is no reason to stop updating.
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	if path[0] == '@' {
+		path = "\x00" + path[1:] // Abstract socket namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		logWarnf("Failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		logWarnf("Failed to notify systemd: %v", err)
+	}
+}
+
+// sdWatchdog returns a channel ticking at half the watchdog timeout configured via
+// the WatchdogSec= unit setting, or nil if the watchdog is not enabled for the
+// updater's process.
+func sdWatchdog() <-chan time.Time {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return time.NewTicker(time.Duration(usec) * time.Microsecond / 2).C
+}
@@ -0,0 +1,247 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ovhEndpoints are the API base URLs of the OVH regions.
+var ovhEndpoints = map[string]string{
+	"ovh-eu": "https://eu.api.ovh.com/1.0",
+	"ovh-ca": "https://ca.api.ovh.com/1.0",
+	"ovh-us": "https://api.us.ovhcloud.com/1.0",
+}
+
+// ovhProvider is the OVH DNS provider, updating the records of zones through the
+// REST API, authenticated with an application key, secret and consumer key.
+type ovhProvider struct {
+	client   *http.Client // HTTP client to execute the API calls with
+	endpoint string       // Base URL of the API of the account's region
+
+	appKey      string // Application key identifying this updater
+	appSecret   string // Application secret to sign requests with
+	consumerKey string // Consumer key authorizing access to the account
+
+	skew     time.Duration // Clock difference between the API and the local machine
+	skewOnce sync.Once
+}
+
+// newOVHProvider creates an OVH DNS provider in the given region, falling back
+// to the standard environment variables for any missing credential.
+func newOVHProvider(client *http.Client, region string, appKey string, appSecret string, consumerKey string) (*ovhProvider, error) {
+	if region == "" {
+		region = os.Getenv("OVH_ENDPOINT")
+	}
+	if region == "" {
+		region = "ovh-eu"
+	}
+	endpoint, ok := ovhEndpoints[region]
+	if !ok {
+		return nil, fmt.Errorf("unknown OVH endpoint: %s", region)
+	}
+	if appKey == "" {
+		appKey = os.Getenv("OVH_APPLICATION_KEY")
+	}
+	if appSecret == "" {
+		appSecret = os.Getenv("OVH_APPLICATION_SECRET")
+	}
+	if consumerKey == "" {
+		consumerKey = os.Getenv("OVH_CONSUMER_KEY")
+	}
+	if appKey == "" || appSecret == "" || consumerKey == "" {
+		return nil, errors.New("incomplete credentials, use -ovh-app-key, -ovh-app-secret and -ovh-consumer-key")
+	}
+	return &ovhProvider{
+		client:      client,
+		endpoint:    endpoint,
+		appKey:      appKey,
+		appSecret:   appSecret,
+		consumerKey: consumerKey,
+	}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *ovhProvider) String() string {
+	return "ovh"
+}
+
+// ovhRecord is a DNS record as represented by the OVH API.
+type ovhRecord struct {
+	ID        int64  `json:"id,omitempty"`
+	FieldType string `json:"fieldType,omitempty"`
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       int    `json:"ttl"`
+}
+
+// verify implements provider, checking that the zones of all domains are
+// accessible with the configured credentials.
+func (p *ovhProvider) verify(domains []*domain) error {
+	for _, host := range domains {
+		zone, err := hostZone(host)
+		if err != nil {
+			return err
+		}
+		if err := p.call("GET", "/domain/zone/"+url.PathEscape(zone), nil, nil); err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 404") {
+				return fmt.Errorf("zone of %s not accessible (missing /domain/zone/* access rule?): %v", host, err)
+			}
+			logErrorf("Failed to verify zone of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record of a domain to the given
+// address, according to the configured policy for multiple records. As OVH only
+// serves changes after the zone is refreshed, that is requested after updates.
+func (p *ovhProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = hostZone(host); err != nil {
+			return false, err
+		}
+	}
+	var (
+		endpoint = "/domain/zone/" + url.PathEscape(host.zoneID) + "/record"
+		name     = relativeName(host.name, host.zoneID)
+	)
+	if name == "@" {
+		name = "" // OVH denotes the zone apex with an empty subdomain
+	}
+	query := url.Values{}
+	query.Set("fieldType", kind)
+	query.Set("subDomain", name)
+
+	var ids []int64
+	if err := p.call("GET", endpoint+"?"+query.Encode(), nil, &ids); err != nil {
+		return false, fmt.Errorf("record retrieval failed: %v", err)
+	}
+	recs := make([]ovhRecord, len(ids))
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		if err := p.call("GET", endpoint+"/"+strconv.FormatInt(id, 10), nil, &recs[i]); err != nil {
+			return false, fmt.Errorf("record retrieval failed: %v", err)
+		}
+		values[i] = recs[i].Target
+	}
+	if len(recs) == 0 && !*createFlag {
+		return false, fmt.Errorf("invalid number of DNS records found: %+v", recs)
+	}
+	index, stale, err := pickValue(values, address, previous)
+	if err != nil {
+		return false, err
+	}
+	// Assemble the new record, leaving automatic TTLs to the zone default
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl == 1 {
+		ttl = 0
+	}
+	update := ovhRecord{SubDomain: name, Target: address, TTL: ttl}
+
+	changed := len(stale) > 0
+	switch {
+	case index < 0:
+		update.FieldType = kind
+		if err := p.call("POST", endpoint, update, nil); err != nil {
+			return false, fmt.Errorf("dns record creation failed: %v", err)
+		}
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+		changed = true
+
+	case recs[index].Target != address || recs[index].TTL != ttl:
+		if err := p.call("PUT", endpoint+"/"+strconv.FormatInt(recs[index].ID, 10), update, nil); err != nil {
+			return false, fmt.Errorf("dns record update failed: %v", err)
+		}
+		changed = true
+	}
+	// Delete the records made superfluous by the multiple records policy
+	for _, i := range stale {
+		if err := p.call("DELETE", endpoint+"/"+strconv.FormatInt(recs[i].ID, 10), nil, nil); err != nil {
+			logErrorf("Failed to delete superfluous record of %s (%s): %v", host, values[i], err)
+			continue
+		}
+		logInfof("Deleted superfluous record of %s (%s)", host, values[i])
+	}
+	// Apply the changes to the served zone
+	if changed {
+		if err := p.call("POST", "/domain/zone/"+url.PathEscape(host.zoneID)+"/refresh", nil, nil); err != nil {
+			return false, fmt.Errorf("zone refresh failed: %v", err)
+		}
+	}
+	return changed, nil
+}
+
+// call executes a signed OVH API request. Signatures include a timestamp, so
+// the clock skew to the API is measured once and applied to all requests.
+func (p *ovhProvider) call(method string, path string, payload interface{}, result interface{}) error {
+	p.skewOnce.Do(func() {
+		req, err := http.NewRequest("GET", p.endpoint+"/auth/time", nil)
+		if err != nil {
+			return
+		}
+		res, err := apiCall(p.client, req)
+		if err != nil {
+			logErrorf("Failed to retrieve OVH API time: %v", err)
+			return
+		}
+		if now, err := strconv.ParseInt(strings.TrimSpace(string(res)), 10, 64); err == nil {
+			p.skew = time.Until(time.Unix(now, 0))
+		}
+	})
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+	endpoint := p.endpoint + path
+	if simulated(method, endpoint, payload) {
+		return nil
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Add(p.skew).Unix(), 10)
+	signature := sha1.Sum([]byte(strings.Join([]string{p.appSecret, p.consumerKey, method, endpoint, string(body), timestamp}, "+")))
+
+	req.Header.Set("X-Ovh-Application", p.appKey)
+	req.Header.Set("X-Ovh-Consumer", p.consumerKey)
+	req.Header.Set("X-Ovh-Timestamp", timestamp)
+	req.Header.Set("X-Ovh-Signature", "$1$"+hex.EncodeToString(signature[:]))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := apiCall(p.client, req)
+	if err != nil {
+		return err
+	}
+	if result != nil && len(res) > 0 {
+		if err := json.Unmarshal(res, result); err != nil {
+			return fmt.Errorf("invalid API response: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// ownerPrefix is the label prepended to a domain name to form the name of its
+// companion TXT record, marking which updater instance owns the domain.
+const ownerPrefix = "_dyndns."
+
+// ownerMarker returns the name and content of the ownership TXT record of a
+// domain. Wildcards cannot have labels prepended, so they are spelled out.
+func ownerMarker(host *domain, owner string) (string, string) {
+	name := strings.Replace(host.name, "*", "wildcard", 1)
+	return ownerPrefix + name, "heritage=cloudflare-dyndns,owner=" + owner
+}
+
+// claimOwnership ensures that a domain is owned by this updater instance before
+// its records are modified. Domains owned by another instance are refused, just
+// as existing records without an owner (unless adopting is enabled). New records
+// (and adopted ones) get their ownership marker created.
+func claimOwnership(api *cloudflare.API, zone string, host *domain, exists bool) error {
+	name, content := ownerMarker(host, *ownerFlag)
+
+	markers, err := findRecords(api, zone, name, "TXT")
+	if err != nil {
+		return fmt.Errorf("ownership resolution failed: %v", err)
+	}
+	for _, marker := range markers {
+		value := strings.Trim(marker.Content, "\"")
+		if value == content {
+			return nil
+		}
+		if strings.HasPrefix(value, "heritage=cloudflare-dyndns,") {
+			return fmt.Errorf("domain owned by another updater: %s", value)
+		}
+	}
+	if exists && !*adoptFlag {
+		return fmt.Errorf("existing record not owned by this updater, use -adopt to claim it")
+	}
+	// Domain free to claim, create the ownership marker
+	marker := recordUpdate{
+		Type:    "TXT",
+		Name:    name,
+		Content: content,
+		TTL:     1,
+	}
+	if _, err := callAPI(api, "POST", "/zones/"+zone+"/dns_records", marker); err != nil {
+		return fmt.Errorf("ownership marker creation failed: %v", err)
+	}
+	logInfof("Claimed ownership of %s as %s", host, *ownerFlag)
+	return nil
+}
@@ -0,0 +1,200 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// pluginPrefix is the prefix of the executables implementing external providers,
+// followed by the name of the provider (e.g. cloudflare-dyndns-provider-foo).
+const pluginPrefix = "cloudflare-dyndns-provider-"
+
+// pluginVersion is the version of the plugin protocol, sent along every request
+// so plugins can reject ones they don't understand.
+const pluginVersion = 1
+
+// pluginNameMatcher is a regexp to check that a provider name is safe to derive
+// an executable name from.
+var pluginNameMatcher = regexp.MustCompile("^[a-z0-9][a-z0-9_-]*$")
+
+// pluginSecrets are the prefixes of the environment variables holding the
+// updater's own configuration and the credentials of the built in providers and
+// secret stores, which are never passed on to plugins.
+var pluginSecrets = []string{
+	envPrefix, "AWS_", "AZURE_", "VAULT_", "GOOGLE_APPLICATION_CREDENTIALS=",
+	"DESEC_", "GANDI_", "HETZNER_", "NAMECHEAP_", "OVH_", "PORKBUN_",
+}
+
+// pluginEnv filters the environment of the updater down to what plugins may see,
+// keeping everything (e.g. PATH, HOME and the plugin's own settings) apart from
+// the updater's secrets.
+func pluginEnv(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		secret := false
+		for _, prefix := range pluginSecrets {
+			if strings.HasPrefix(kv, prefix) {
+				secret = true
+				break
+			}
+		}
+		if !secret {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// pluginProvider is a DNS provider implemented by an external executable, so
+// niche DNS hosts can be supported without compiling them into the updater. The
+// plugin is run for every operation, receiving a single JSON request on its
+// standard input and replying with a single JSON object on its standard output.
+type pluginProvider struct {
+	name string // Name of the provider the plugin implements
+	path string // Path of the plugin executable
+}
+
+// pluginDomain is a domain as represented by the plugin protocol.
+type pluginDomain struct {
+	Name string `json:"name"`           // Fully qualified name of the record
+	Zone string `json:"zone,omitempty"` // Explicit zone of the record, if any
+	TTL  int    `json:"ttl,omitempty"`  // Explicit TTL of the record, if any (1 = auto)
+}
+
+// pluginRequest is an operation requested from a plugin.
+type pluginRequest struct {
+	Version  int            `json:"version"`            // Version of the plugin protocol
+	Method   string         `json:"method"`             // Operation to execute (verify or upsert)
+	Domains  []pluginDomain `json:"domains,omitempty"`  // Domains to verify access to
+	Domain   *pluginDomain  `json:"domain,omitempty"`   // Domain to update the record of
+	Type     string         `json:"type,omitempty"`     // Record type to update (A or AAAA)
+	Address  string         `json:"address,omitempty"`  // Address to set the record to
+	Previous string         `json:"previous,omitempty"` // Previously published address, if known
+	TTL      int            `json:"ttl,omitempty"`      // Time to live of the record (1 = auto)
+	Create   bool           `json:"create,omitempty"`   // Whether missing records may be created
+	Multi    string         `json:"multi,omitempty"`    // Policy for multiple records of a type
+}
+
+// pluginReply is the outcome of an operation returned by a plugin.
+type pluginReply struct {
+	Changed bool   `json:"changed"`         // Whether the record was changed at all
+	Error   string `json:"error,omitempty"` // Failure of the operation, if any
+}
+
+// newPluginProvider looks up the executable of an external provider, either in
+// the configured plugin directory or on the PATH.
+func newPluginProvider(name string, dir string) (*pluginProvider, error) {
+	if !pluginNameMatcher.MatchString(name) {
+		return nil, fmt.Errorf("unknown DNS provider: %s", name)
+	}
+	executable := pluginPrefix + name
+	if dir != "" {
+		executable = filepath.Join(dir, executable)
+	}
+	path, err := exec.LookPath(executable)
+	if err != nil {
+		return nil, fmt.Errorf("unknown DNS provider %s (no %s plugin found)", name, executable)
+	}
+	return &pluginProvider{name: name, path: path}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *pluginProvider) String() string {
+	return p.name
+}
+
+// verify implements provider, asking the plugin to check access to the domains.
+// Errors reported by the plugin are considered configuration issues, whereas
+// failing to run it at all is only logged.
+func (p *pluginProvider) verify(domains []*domain) error {
+	req := &pluginRequest{Version: pluginVersion, Method: "verify"}
+	for _, host := range domains {
+		req.Domains = append(req.Domains, pluginDomain{Name: host.name, Zone: host.zone, TTL: host.ttl})
+	}
+	reply, err := p.call(req)
+	if err != nil {
+		logErrorf("Failed to verify %s plugin: %v", p.name, err)
+		return nil
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	return nil
+}
+
+// upsert implements provider, asking the plugin to update the record of a domain.
+// The TTL and the record policies are forwarded as configured, enforcing them is
+// up to the plugin.
+func (p *pluginProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if *dryRunFlag {
+		logInfof("Dry run, not running: %s plugin upsert %s %s -> %s (ttl %d)", p.name, host.name, kind, address, ttl)
+		return true, nil
+	}
+	reply, err := p.call(&pluginRequest{
+		Version:  pluginVersion,
+		Method:   "upsert",
+		Domain:   &pluginDomain{Name: host.name, Zone: host.zone, TTL: host.ttl},
+		Type:     kind,
+		Address:  address,
+		Previous: previous,
+		TTL:      ttl,
+		Create:   *createFlag,
+		Multi:    *multiFlag,
+	})
+	if err != nil {
+		return false, fmt.Errorf("plugin failed: %v", err)
+	}
+	if reply.Error != "" {
+		return false, errors.New(reply.Error)
+	}
+	return reply.Changed, nil
+}
+
+// call runs the plugin with a single request, returning its reply. Anything the
+// plugin prints to its standard error is attached to the error if it fails, or
+// logged otherwise.
+func (p *pluginProvider) call(req *pluginRequest) (*pluginReply, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(aborting, *apiTimeoutFlag)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Env = append(pluginEnv(os.Environ()), fmt.Sprintf("CF_DDNS_PLUGIN_VERSION=%d", pluginVersion))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(input), &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		logWarnf("Plugin %s: %s", p.name, msg)
+	}
+	reply := new(pluginReply)
+	if err := json.Unmarshal(stdout.Bytes(), reply); err != nil {
+		return nil, fmt.Errorf("invalid plugin reply: %v", err)
+	}
+	return reply, nil
+}
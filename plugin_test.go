@@ -0,0 +1,33 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that the secrets of the updater are stripped from the environment of
+// plugins, while everything else is passed on.
+func TestPluginEnv(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"HOME=/home/user",
+		"EXAMPLE_TOKEN=plugin-secret",
+		"CF_DDNS_TOKEN=cloudflare-secret",
+		"AWS_SECRET_ACCESS_KEY=aws-secret",
+		"AZURE_CLIENT_SECRET=azure-secret",
+		"VAULT_TOKEN=vault-secret",
+		"PORKBUN_SECRET_API_KEY=porkbun-secret",
+		"GOOGLE_APPLICATION_CREDENTIALS=/etc/google.json",
+		"GOOGLE_CLOUD_PROJECT=project",
+	}
+	have := strings.Join(pluginEnv(environ), " ")
+	want := "PATH=/usr/bin HOME=/home/user EXAMPLE_TOKEN=plugin-secret GOOGLE_CLOUD_PROJECT=project"
+	if have != want {
+		t.Errorf("environment mismatch: have %q, want %q", have, want)
+	}
+}
@@ -0,0 +1,200 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// porkbunEndpoint is the base URL of the Porkbun API.
+const porkbunEndpoint = "https://api.porkbun.com/api/json/v3"
+
+// porkbunMinTTL is the minimum TTL accepted by Porkbun, also used for auto.
+const porkbunMinTTL = 600
+
+// porkbunProvider is the Porkbun DNS provider, updating the records of domains
+// through the JSON API, authenticated with an API key and secret key pair.
+type porkbunProvider struct {
+	client *http.Client // HTTP client to execute the API calls with
+	key    string       // API key of the account
+	secret string       // Secret API key of the account
+}
+
+// newPorkbunProvider creates a Porkbun DNS provider with the given API keys,
+// falling back to the environment for any missing one.
+func newPorkbunProvider(client *http.Client, key string, secret string) (*porkbunProvider, error) {
+	if key == "" {
+		key = os.Getenv("PORKBUN_API_KEY")
+	}
+	if secret == "" {
+		secret = os.Getenv("PORKBUN_SECRET_API_KEY")
+	}
+	if key == "" || secret == "" {
+		return nil, errors.New("incomplete credentials, use -porkbun-key and -porkbun-secret")
+	}
+	return &porkbunProvider{client: client, key: key, secret: secret}, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *porkbunProvider) String() string {
+	return "porkbun"
+}
+
+// porkbunRecord is a DNS record as represented by the Porkbun API. Numeric
+// fields are sent and returned as strings.
+type porkbunRecord struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     string `json:"ttl"`
+}
+
+// verify implements provider, checking the credentials and that API access was
+// enabled for all the domains.
+func (p *porkbunProvider) verify(domains []*domain) error {
+	if err := p.call("/ping", nil, nil); err != nil {
+		if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 400") {
+			return fmt.Errorf("credential verification failed: %v", err)
+		}
+		logErrorf("Failed to verify Porkbun credentials: %v", err)
+		return nil
+	}
+	for _, host := range domains {
+		zone, err := hostZone(host)
+		if err != nil {
+			return err
+		}
+		if _, err := p.records(zone, host, "A"); err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "HTTP status 400") {
+				return fmt.Errorf("domain %s not accessible (API access not enabled?): %v", zone, err)
+			}
+			logErrorf("Failed to verify domain of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record of a domain to the given
+// address, according to the configured policy for multiple records.
+func (p *porkbunProvider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = hostZone(host); err != nil {
+			return false, err
+		}
+	}
+	recs, err := p.records(host.zoneID, host, kind)
+	if err != nil {
+		return false, fmt.Errorf("record retrieval failed: %v", err)
+	}
+	if len(recs) == 0 && !*createFlag {
+		return false, fmt.Errorf("invalid number of DNS records found: %+v", recs)
+	}
+	values := make([]string, len(recs))
+	for i, rec := range recs {
+		values[i] = rec.Content
+	}
+	index, stale, err := pickValue(values, address, previous)
+	if err != nil {
+		return false, err
+	}
+	// Assemble the new record within the TTL limits of Porkbun
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl < porkbunMinTTL {
+		ttl = porkbunMinTTL
+	}
+	name := relativeName(host.name, host.zoneID)
+	if name == "@" {
+		name = "" // Porkbun denotes the zone apex with an empty name
+	}
+	update := porkbunRecord{Name: name, Type: kind, Content: address, TTL: strconv.Itoa(ttl)}
+	zone := url.PathEscape(host.zoneID)
+
+	changed := len(stale) > 0
+	switch {
+	case index < 0:
+		if err := p.call("/dns/create/"+zone, &update, nil); err != nil {
+			return false, fmt.Errorf("dns record creation failed: %v", err)
+		}
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+		changed = true
+
+	case recs[index].Content != address || recs[index].TTL != update.TTL:
+		if err := p.call("/dns/edit/"+zone+"/"+url.PathEscape(recs[index].ID), &update, nil); err != nil {
+			return false, fmt.Errorf("dns record update failed: %v", err)
+		}
+		changed = true
+	}
+	// Delete the records made superfluous by the multiple records policy
+	for _, i := range stale {
+		if err := p.call("/dns/delete/"+zone+"/"+url.PathEscape(recs[i].ID), nil, nil); err != nil {
+			logErrorf("Failed to delete superfluous record of %s (%s): %v", host, values[i], err)
+			continue
+		}
+		logInfof("Deleted superfluous record of %s (%s)", host, values[i])
+	}
+	return changed, nil
+}
+
+// records retrieves the records of a domain with the given type.
+func (p *porkbunProvider) records(zone string, host *domain, kind string) ([]porkbunRecord, error) {
+	path := "/dns/retrieveByNameType/" + url.PathEscape(zone) + "/" + kind
+	if name := relativeName(host.name, zone); name != "@" {
+		path += "/" + url.PathEscape(name)
+	}
+	var reply struct {
+		Records []porkbunRecord `json:"records"`
+	}
+	if err := p.call(path, nil, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Records, nil
+}
+
+// call executes a Porkbun API request. Every endpoint is a POST, with the API
+// keys embedded into the JSON payload along with the record (if any).
+func (p *porkbunProvider) call(path string, rec *porkbunRecord, result interface{}) error {
+	// Every call is a POST, so only simulate the ones changing records
+	if !strings.HasPrefix(path, "/dns/retrieve") && strings.HasPrefix(path, "/dns/") && simulated("POST", porkbunEndpoint+path, rec) {
+		return nil
+	}
+	params := map[string]string{"apikey": p.key, "secretapikey": p.secret}
+	if rec != nil {
+		params["name"], params["type"], params["content"], params["ttl"] = rec.Name, rec.Type, rec.Content, rec.TTL
+	}
+	var reply json.RawMessage
+	if err := jsonCall(p.client, "POST", porkbunEndpoint+path, nil, params, &reply); err != nil {
+		return err
+	}
+	var status struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(reply, &status); err != nil {
+		return fmt.Errorf("invalid API response: %v", err)
+	}
+	if status.Status != "SUCCESS" {
+		return fmt.Errorf("porkbun replied %s: %s", status.Status, status.Message)
+	}
+	if result != nil {
+		if err := json.Unmarshal(reply, result); err != nil {
+			return fmt.Errorf("invalid API response: %v", err)
+		}
+	}
+	return nil
+}
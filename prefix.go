@@ -0,0 +1,72 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// delegatedBits is the length of the delegated IPv6 prefix last reported by the
+// gateway (0 = unknown), used if requested via -prefix-length 0.
+var delegatedBits int32
+
+// recordPrefix stores the length of the delegated IPv6 prefix reported by the
+// gateway.
+func recordPrefix(bits int) {
+	atomic.StoreInt32(&delegatedBits, int32(bits))
+}
+
+// prefixBits returns the length of the delegated IPv6 prefix to combine suffixes
+// with: the configured one, or if set to 0, the one reported by the gateway (/64
+// until one is known).
+func prefixBits() int {
+	if *prefixFlag > 0 {
+		return *prefixFlag
+	}
+	if bits := atomic.LoadInt32(&delegatedBits); bits > 0 {
+		return int(bits)
+	}
+	return 64
+}
+
+// parseSuffixes parses a comma separated list of host=suffix pairs, where each
+// suffix is an IPv6 interface identifier (e.g. ::211:32ff:fe12:3456) to combine
+// with the current delegated prefix.
+func parseSuffixes(list string) (map[string]net.IP, error) {
+	suffixes := make(map[string]net.IP)
+	for _, item := range splitList(list) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid suffix mapping: %s", item)
+		}
+		suffix := net.ParseIP(strings.TrimSpace(parts[1]))
+		if suffix == nil || suffix.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 suffix for %s: %s", parts[0], parts[1])
+		}
+		suffixes[normalizeName(parts[0])] = suffix
+	}
+	return suffixes, nil
+}
+
+// applySuffix replaces the host part of an IPv6 address beyond the given prefix
+// length with the host part of the suffix, deriving the address of another host
+// within the same delegated prefix.
+func applySuffix(address string, suffix net.IP, bits int) (string, error) {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() != nil {
+		return "", fmt.Errorf("invalid IPv6 address: %s", address)
+	}
+	mask := net.CIDRMask(bits, 128)
+
+	result := make(net.IP, net.IPv6len)
+	for i := range result {
+		result[i] = ip[i]&mask[i] | suffix[i]&^mask[i]
+	}
+	return result.String(), nil
+}
@@ -0,0 +1,109 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// Tests that the addresses of other hosts are derived correctly from the current
+// delegated prefix.
+func TestApplySuffix(t *testing.T) {
+	tests := []struct {
+		address string
+		suffix  string
+		bits    int
+		want    string
+		fail    bool
+	}{
+		{address: "2001:db8:1:2:aaaa:bbbb:cccc:dddd", suffix: "::211:32ff:fe12:3456", bits: 64, want: "2001:db8:1:2:211:32ff:fe12:3456"},
+		{address: "2001:db8:1:2::1", suffix: "::211:32ff:fe12:3456", bits: 64, want: "2001:db8:1:2:211:32ff:fe12:3456"},
+		{address: "2001:db8:1:2::1", suffix: "::3:0:0:0:5", bits: 56, want: "2001:db8:1:3::5"},
+		{address: "2001:db8:1:2::1", suffix: "::5", bits: 48, want: "2001:db8:1::5"},
+		{address: "2001:db8:1:2::1", suffix: "::5", bits: 60, want: "2001:db8:1::5"},
+		{address: "2001:db8:1:2::1", suffix: "::ffff:ffff:ffff:ffff:ffff", bits: 62, want: "2001:db8:1:3:ffff:ffff:ffff:ffff"},
+		{address: "2001:db8:1:2::1", suffix: "::5", bits: 128, want: "2001:db8:1:2::1"},
+		{address: "2001:db8:1:2::1", suffix: "::5", bits: 0, want: "::5"},
+		{address: "192.0.2.1", suffix: "::5", bits: 64, fail: true},
+		{address: "garbage", suffix: "::5", bits: 64, fail: true},
+	}
+	for _, tt := range tests {
+		address, err := applySuffix(tt.address, net.ParseIP(tt.suffix), tt.bits)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s + %s/%d: expected failure, got %s", tt.address, tt.suffix, tt.bits, address)
+			}
+			continue
+		}
+		if err != nil || address != tt.want {
+			t.Errorf("%s + %s/%d: address mismatch: have %s/%v, want %s", tt.address, tt.suffix, tt.bits, address, err, tt.want)
+		}
+	}
+}
+
+// Tests that host suffix mappings are parsed correctly.
+func TestParseSuffixes(t *testing.T) {
+	tests := []struct {
+		list string
+		want map[string]string
+		fail bool
+	}{
+		{list: "", want: map[string]string{}},
+		{list: "nas.example.com=::211:32ff:fe12:3456", want: map[string]string{"nas.example.com": "::211:32ff:fe12:3456"}},
+		{list: " NAS.example.com. = ::5 , tv.example.com=::6", want: map[string]string{"nas.example.com": "::5", "tv.example.com": "::6"}},
+		{list: "nas.example.com", fail: true},
+		{list: "nas.example.com=192.0.2.1", fail: true},
+		{list: "nas.example.com=garbage", fail: true},
+	}
+	for _, tt := range tests {
+		suffixes, err := parseSuffixes(tt.list)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected failure, got %v", tt.list, suffixes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: failed to parse: %v", tt.list, err)
+			continue
+		}
+		if len(suffixes) != len(tt.want) {
+			t.Errorf("%q: suffix count mismatch: have %v, want %v", tt.list, suffixes, tt.want)
+		}
+		for host, suffix := range tt.want {
+			if have, ok := suffixes[host]; !ok || !have.Equal(net.ParseIP(suffix)) {
+				t.Errorf("%q: suffix of %s mismatch: have %v, want %s", tt.list, host, have, suffix)
+			}
+		}
+	}
+}
+
+// Tests that the prefix length reported by the gateway is only used if explicitly
+// requested, keeping /64 by default.
+func TestPrefixBits(t *testing.T) {
+	defer func(bits int) { *prefixFlag = bits }(*prefixFlag)
+	defer recordPrefix(0)
+
+	tests := []struct {
+		configured int
+		delegated  int
+		want       int
+	}{
+		{configured: 64, delegated: 0, want: 64},
+		{configured: 64, delegated: 56, want: 64},
+		{configured: 48, delegated: 56, want: 48},
+		{configured: 0, delegated: 0, want: 64},
+		{configured: 0, delegated: 56, want: 56},
+	}
+	for _, tt := range tests {
+		*prefixFlag = tt.configured
+		recordPrefix(tt.delegated)
+		if bits := prefixBits(); bits != tt.want {
+			t.Errorf("configured %d, delegated %d: prefix length mismatch: have %d, want %d", tt.configured, tt.delegated, bits, tt.want)
+		}
+	}
+}
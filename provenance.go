@@ -0,0 +1,94 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// secretFlags are the flags holding secrets, redacted when printing the settings.
+var secretFlags = map[string]bool{
+	"token":              true,
+	"key":                true,
+	"listen-token":       true,
+	"do-token":           true,
+	"hetzner-token":      true,
+	"ovh-app-secret":     true,
+	"ovh-consumer-key":   true,
+	"gandi-token":        true,
+	"rfc2136-tsig":       true,
+	"dyndns2-password":   true,
+	"desec-token":        true,
+	"porkbun-key":        true,
+	"porkbun-secret":     true,
+	"namecheap-password": true,
+}
+
+// redacted is the placeholder printed instead of secrets.
+const redacted = "<redacted>"
+
+// urlPasswordMatcher is a regexp to find the passwords embedded into URLs (e.g.
+// of proxies or the MikroTik endpoint).
+var urlPasswordMatcher = regexp.MustCompile(`(://[^/:@\s]*):[^/@\s]+@`)
+
+// showConfig prints the effective settings in configuration file syntax, after
+// applying the command line, the environment and the config file, along with
+// where each value came from. Secrets are redacted.
+func showConfig(out io.Writer) {
+	fmt.Fprintln(out, "# Effective configuration (precedence: command line > environment > systemd")
+	fmt.Fprintln(out, "# credentials > config file > Docker secret > default), secrets redacted")
+
+	flag.VisitAll(func(f *flag.Flag) {
+		source, ok := flagSources[f.Name]
+		if !ok {
+			source = "default"
+		}
+		var value string
+		if list, ok := f.Value.(*listFlag); ok {
+			items := make([]string, len(*list))
+			for i, item := range *list {
+				items[i] = strconv.Quote(redactValue(f.Name, item))
+			}
+			value = "[" + strings.Join(items, ", ") + "]"
+		} else if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			value = f.Value.String()
+		} else {
+			value = strconv.Quote(redactValue(f.Name, f.Value.String()))
+		}
+		fmt.Fprintf(out, "%s = %s # %s\n", f.Name, value, source)
+	})
+}
+
+// redactValue hides the secrets within the value of a flag.
+func redactValue(name string, value string) string {
+	if value == "" {
+		return value
+	}
+	switch {
+	case secretFlags[name]:
+		return redacted
+
+	case name == "account":
+		// Account tokens precede the domains, unless sourced from a file
+		if parts := strings.SplitN(value, "=", 2); len(parts) == 2 && !strings.HasPrefix(parts[0], "@") {
+			return redacted + "=" + parts[1]
+		}
+
+	case name == "resolver-header":
+		// Header values may hold authorization secrets, keep only the names
+		if eq := strings.Index(value, "="); eq >= 0 {
+			if index := strings.Index(value[eq:], ":"); index >= 0 {
+				return value[:eq+index] + ": " + redacted
+			}
+		}
+	}
+	return urlPasswordMatcher.ReplaceAllString(value, "$1:"+redacted+"@")
+}
@@ -0,0 +1,268 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// provider is a DNS hosting backend able to maintain the address records of
+// domains. CloudFlare is the default one, but any backend implementing this
+// interface can be used to update a domain without touching the update loop.
+type provider interface {
+	fmt.Stringer
+
+	// verify checks on startup that the provider is reachable and can access
+	// the given domains, failing only if the configuration is wrong (i.e. not
+	// on transient errors).
+	verify(domains []*domain) error
+
+	// upsert resolves the current address record of the given type (A or AAAA)
+	// of a domain and updates it to the given address, creating it if needed
+	// and allowed. The previously published address (if known) can be used to
+	// pick the record to update. The returned flag reports whether anything was
+	// changed at all.
+	upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error)
+}
+
+// newProvider creates the DNS provider with the given name, for domains hosted
+// outside of CloudFlare. The API client is shared across all providers, so the
+// proxy, retry and timeout settings apply to them too.
+func newProvider(name string, client *http.Client) (provider, error) {
+	switch name {
+	case "route53":
+		return newRoute53Provider(client), nil
+	case "google":
+		return newGoogleProvider(client, *gcpCredentialsFlag, *gcpProjectFlag)
+	case "azure":
+		return newAzureProvider(client, *azureSubscriptionFlag, *azureGroupFlag)
+	case "digitalocean":
+		return newDigitalOceanProvider(client, *doTokenFlag)
+	case "hetzner":
+		return newHetznerProvider(client, *hetznerTokenFlag)
+	case "ovh":
+		return newOVHProvider(client, *ovhEndpointFlag, *ovhAppKeyFlag, *ovhAppSecretFlag, *ovhConsumerKeyFlag)
+	case "gandi":
+		return newGandiProvider(client, *gandiTokenFlag)
+	case "rfc2136":
+		return newRFC2136Provider(*rfc2136ServerFlag, *rfc2136KeyFlag)
+	case "dyndns2":
+		return newDynDNS2Provider(client, *dyndns2URLFlag, *dyndns2UserFlag, *dyndns2PasswordFlag)
+	case "desec":
+		return newDesecProvider(client, *desecTokenFlag)
+	case "porkbun":
+		return newPorkbunProvider(client, *porkbunKeyFlag, *porkbunSecretFlag)
+	case "namecheap":
+		return newNamecheapProvider(client, *namecheapPasswordFlag)
+	default:
+		// Anything else is an external provider plugin (or a typo)
+		return newPluginProvider(name, *pluginDirFlag)
+	}
+}
+
+// hostZone returns the name of the zone a domain belongs to, either from its
+// explicit zone setting, or by deriving it from the domain name itself as the
+// registrable domain according to the Public Suffix List.
+func hostZone(host *domain) (string, error) {
+	zone := host.zone
+	if zone == "" {
+		derived, err := publicsuffix.EffectiveTLDPlusOne(host.name)
+		if err != nil {
+			return "", fmt.Errorf("cannot derive zone of %s, specify it explicitly: %v", host, err)
+		}
+		zone = derived
+	}
+	if host.name != zone && !strings.HasSuffix(host.name, "."+zone) {
+		return "", fmt.Errorf("domain %s not within zone %s", host, zone)
+	}
+	return zone, nil
+}
+
+// relativeName returns the name of a domain relative to its zone, as used by
+// the APIs of most DNS providers, with the zone apex denoted by @.
+func relativeName(name string, zone string) string {
+	if name == zone {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+// mergeValues applies the configured policy for multiple records to providers
+// that manage all the addresses of a name as a single record set, returning the
+// new set of values holding the given address. The picked value is replaced (or
+// the address added if none was picked) and the superfluous ones dropped, as
+// decided by pickValue.
+func mergeValues(values []string, address string, previous string) ([]string, error) {
+	if len(values) == 0 {
+		return []string{address}, nil
+	}
+	index, stale, err := pickValue(values, address, previous)
+	if err != nil {
+		return nil, err
+	}
+	merged := append([]string{}, values...)
+	if index < 0 {
+		return append(merged, address), nil
+	}
+	merged[index] = address
+	for i := len(stale) - 1; i >= 0; i-- {
+		merged = append(merged[:stale[i]], merged[stale[i]+1:]...)
+	}
+	return merged, nil
+}
+
+// convergeValues picks the value owned by this host out of the ones of a name
+// (the current address, or failing that the previous one), returning its index
+// (-1 if none) and the indices of any other values duplicating this host's
+// addresses, in ascending order. Values of other hosts are never picked.
+func convergeValues(values []string, address string, previous string) (int, []int) {
+	index := -1
+	for i, value := range values {
+		if value == address {
+			index = i
+			break
+		}
+	}
+	if index < 0 && previous != "" {
+		for i, value := range values {
+			if value == previous {
+				index = i
+				break
+			}
+		}
+	}
+	if index < 0 {
+		return -1, nil
+	}
+	var stale []int
+	for i, value := range values {
+		if i != index && (value == address || (previous != "" && value == previous)) {
+			stale = append(stale, i)
+		}
+	}
+	return index, stale
+}
+
+// simulated reports whether a change to be sent to a DNS provider is to be skipped
+// in dry run mode, logging what would have been sent instead. Read only requests
+// are never simulated.
+func simulated(method string, endpoint string, payload interface{}) bool {
+	if !*dryRunFlag || method == "GET" || method == "HEAD" {
+		return false
+	}
+	var body string
+	switch payload := payload.(type) {
+	case nil:
+	case []byte:
+		body = " " + string(payload)
+	default:
+		blob, _ := json.Marshal(payload)
+		body = " " + string(blob)
+	}
+	logInfof("Dry run, not sending: %s %s%s", method, endpoint, body)
+	return true
+}
+
+// apiCall executes a request against the API of a DNS provider, returning the
+// body of the reply. Non-2xx replies are returned as errors holding the status
+// code and the body, so authorization failures can be detected via deniedAPI.
+func apiCall(client *http.Client, req *http.Request) ([]byte, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	blob, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP status %d: %s", res.StatusCode, strings.TrimSpace(string(blob)))
+	}
+	return blob, nil
+}
+
+// jsonCall executes a JSON request against the API of a DNS provider, sending
+// the given payload (if not nil) and decoding the reply into the result (if not
+// nil). The headers are set on the request as is, typically for authorization.
+func jsonCall(client *http.Client, method string, endpoint string, header http.Header, payload interface{}, result interface{}) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := apiCall(client, req)
+	if err != nil {
+		return err
+	}
+	if result != nil && len(res) > 0 {
+		if err := json.Unmarshal(res, result); err != nil {
+			return fmt.Errorf("invalid API response: %v", err)
+		}
+	}
+	return nil
+}
+
+// pickValue applies the configured policy for multiple records to the addresses
+// of a name, returning the index of the one to update with the given address (-1
+// if a new one is needed) and the indices of the superfluous ones to delete, in
+// ascending order:
+//
+//   - fail:     refuse to update domains with multiple addresses
+//   - one:      update the first address, leaving the others alone
+//   - replace:  update the first address, deleting all the others
+//   - converge: update the previous address (if any), adding the new one
+//     otherwise, deleting duplicates of it but leaving the addresses of other
+//     hosts alone
+//
+// It is shared by every provider, whether managing the addresses as individual
+// records or as a single record set (via mergeValues), the records being taken
+// in the order the provider returned them (or oldest first on CloudFlare).
+func pickValue(values []string, address string, previous string) (int, []int, error) {
+	if len(values) == 0 {
+		return -1, nil, nil
+	}
+	switch *multiFlag {
+	case "one":
+		return 0, nil, nil
+	case "replace":
+		var stale []int
+		for i := 1; i < len(values); i++ {
+			stale = append(stale, i)
+		}
+		return 0, stale, nil
+	case "converge":
+		index, stale := convergeValues(values, address, previous)
+		return index, stale, nil
+	default:
+		if len(values) > 1 {
+			return -1, nil, fmt.Errorf("invalid number of DNS records found: %v", values)
+		}
+		return 0, nil, nil
+	}
+}
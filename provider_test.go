@@ -0,0 +1,101 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Tests that the policy for multiple records is applied to record sets managed
+// as a whole.
+func TestMergeValues(t *testing.T) {
+	defer func(policy string) { *multiFlag = policy }(*multiFlag)
+
+	tests := []struct {
+		policy   string
+		values   []string
+		previous string
+		want     []string
+		fail     bool
+	}{
+		// Every policy handles empty and single value sets the same way
+		{policy: "fail", values: nil, want: []string{"9.9.9.9"}},
+		{policy: "fail", values: []string{"1.1.1.1"}, want: []string{"9.9.9.9"}},
+		{policy: "one", values: []string{"1.1.1.1"}, want: []string{"9.9.9.9"}},
+		{policy: "replace", values: []string{"1.1.1.1"}, want: []string{"9.9.9.9"}},
+		{policy: "converge", values: nil, want: []string{"9.9.9.9"}},
+
+		// Multiple values are handled according to the policy
+		{policy: "fail", values: []string{"1.1.1.1", "2.2.2.2"}, fail: true},
+		{policy: "one", values: []string{"1.1.1.1", "2.2.2.2"}, want: []string{"9.9.9.9", "2.2.2.2"}},
+		{policy: "replace", values: []string{"1.1.1.1", "2.2.2.2"}, want: []string{"9.9.9.9"}},
+
+		// Converging only touches the addresses of this host
+		{policy: "converge", values: []string{"1.1.1.1", "2.2.2.2"}, previous: "2.2.2.2", want: []string{"1.1.1.1", "9.9.9.9"}},
+		{policy: "converge", values: []string{"1.1.1.1", "2.2.2.2"}, previous: "3.3.3.3", want: []string{"1.1.1.1", "2.2.2.2", "9.9.9.9"}},
+		{policy: "converge", values: []string{"1.1.1.1", "2.2.2.2"}, want: []string{"1.1.1.1", "2.2.2.2", "9.9.9.9"}},
+		{policy: "converge", values: []string{"1.1.1.1", "9.9.9.9"}, previous: "1.1.1.1", want: []string{"9.9.9.9"}},
+		{policy: "converge", values: []string{"2.2.2.2", "9.9.9.9", "9.9.9.9"}, want: []string{"2.2.2.2", "9.9.9.9"}},
+		{policy: "converge", values: []string{"1.1.1.1", "2.2.2.2", "1.1.1.1", "9.9.9.9"}, previous: "1.1.1.1", want: []string{"2.2.2.2", "9.9.9.9"}},
+		{policy: "converge", values: []string{"1.1.1.1", "2.2.2.2", "1.1.1.1"}, previous: "1.1.1.1", want: []string{"9.9.9.9", "2.2.2.2"}},
+	}
+	for i, tt := range tests {
+		*multiFlag = tt.policy
+
+		merged, err := mergeValues(append([]string{}, tt.values...), "9.9.9.9", tt.previous)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure, got %v", i, merged)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(merged) != fmt.Sprint(tt.want) {
+			t.Errorf("test %d (%s %v, previous %q): merge mismatch: have %v/%v, want %v", i, tt.policy, tt.values, tt.previous, merged, err, tt.want)
+		}
+	}
+}
+
+// Tests that the policy for multiple records is applied to providers managing
+// every address as an individual record.
+func TestPickValue(t *testing.T) {
+	defer func(policy string) { *multiFlag = policy }(*multiFlag)
+
+	tests := []struct {
+		policy   string
+		values   []string
+		previous string
+		index    int
+		stale    []int
+		fail     bool
+	}{
+		{policy: "fail", values: nil, index: -1},
+		{policy: "fail", values: []string{"1.1.1.1"}, index: 0},
+		{policy: "fail", values: []string{"1.1.1.1", "2.2.2.2"}, fail: true},
+		{policy: "one", values: []string{"1.1.1.1", "2.2.2.2"}, index: 0},
+		{policy: "replace", values: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, index: 0, stale: []int{1, 2}},
+		{policy: "converge", values: nil, index: -1},
+		{policy: "converge", values: []string{"1.1.1.1", "2.2.2.2"}, index: -1},
+		{policy: "converge", values: []string{"1.1.1.1", "2.2.2.2"}, previous: "2.2.2.2", index: 1},
+		{policy: "converge", values: []string{"1.1.1.1", "9.9.9.9"}, previous: "1.1.1.1", index: 1, stale: []int{0}},
+		{policy: "converge", values: []string{"9.9.9.9", "2.2.2.2", "9.9.9.9"}, index: 0, stale: []int{2}},
+		{policy: "converge", values: []string{"1.1.1.1", "2.2.2.2", "1.1.1.1", "9.9.9.9"}, previous: "1.1.1.1", index: 3, stale: []int{0, 2}},
+	}
+	for i, tt := range tests {
+		*multiFlag = tt.policy
+
+		index, stale, err := pickValue(tt.values, "9.9.9.9", tt.previous)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure, got %d/%v", i, index, stale)
+			}
+			continue
+		}
+		if err != nil || index != tt.index || fmt.Sprint(stale) != fmt.Sprint(tt.stale) {
+			t.Errorf("test %d (%s %v, previous %q): pick mismatch: have %d/%v/%v, want %d/%v", i, tt.policy, tt.values, tt.previous, index, stale, err, tt.index, tt.stale)
+		}
+	}
+}
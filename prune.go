@@ -0,0 +1,146 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// prune cleans up the records of all the managed CloudFlare domains, deleting
+// duplicate A and AAAA records (keeping the most recently modified one of each
+// type). Policies admitting multiple records of a type are honored: converging
+// domains only lose records duplicating the content of others, while with the
+// one policy the records are left untouched. If ownership tracking is enabled,
+// the records of domains still marked as owned by this updater but no longer
+// configured are deleted too.
+func prune(domains []*domain) error {
+	var failed bool
+
+	// Deduplicate the records of the configured domains
+	zones := make(map[string]*cloudflare.API)
+	for _, host := range domains {
+		provider, ok := host.provider.(*cloudflareProvider)
+		if !ok {
+			continue
+		}
+		api := provider.api
+		if host.zoneID == "" {
+			zone, err := resolveZone(api, host)
+			if err != nil {
+				logErrorf("Failed to resolve zone of %s: %v", host, err)
+				failed = true
+				continue
+			}
+			host.zoneID = zone
+		}
+		zones[host.zoneID] = api
+
+		for _, kind := range []string{"A", "AAAA"} {
+			if err := pruneDuplicates(api, host.zoneID, host.name, kind); err != nil {
+				logErrorf("Failed to prune %s (%s): %v", host, kind, err)
+				failed = true
+			}
+		}
+		host.records = nil
+	}
+	// Drop the leftovers of previously configured domains
+	if *ownerFlag != "" {
+		for zone, api := range zones {
+			if err := pruneOrphans(api, zone, domains); err != nil {
+				logErrorf("Failed to prune leftovers of zone %s: %v", zone, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return fmt.Errorf("some records could not be pruned")
+	}
+	return nil
+}
+
+// pruneDuplicates deletes all but the most recently modified record of a name
+// and type, or when converging, all but the most recently modified record of
+// every distinct content.
+func pruneDuplicates(api *cloudflare.API, zone string, name string, kind string) error {
+	if *multiFlag == "one" {
+		return nil
+	}
+	recs, err := findRecords(api, zone, name, kind)
+	if err != nil {
+		return err
+	}
+	if len(recs) < 2 {
+		return nil
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].ModifiedOn.After(recs[j].ModifiedOn) })
+
+	var stale []cloudflare.DNSRecord
+	if *multiFlag == "converge" {
+		seen := make(map[string]bool)
+		for _, rec := range recs {
+			if seen[rec.Content] {
+				stale = append(stale, rec)
+			}
+			seen[rec.Content] = true
+		}
+	} else {
+		stale = recs[1:]
+	}
+	for _, rec := range stale {
+		if _, err := callAPI(api, "DELETE", "/zones/"+zone+"/dns_records/"+rec.ID, nil); err != nil {
+			return err
+		}
+		logInfof("Pruned duplicate record of %s (%s %s)", name, kind, rec.Content)
+	}
+	return nil
+}
+
+// pruneOrphans deletes the A and AAAA records (and the ownership markers) of all
+// the domains in a zone that are owned by this updater but not configured any
+// more.
+func pruneOrphans(api *cloudflare.API, zone string, domains []*domain) error {
+	configured := make(map[string]bool)
+	for _, host := range domains {
+		name, _ := ownerMarker(host, *ownerFlag)
+		configured[name] = true
+	}
+	_, content := ownerMarker(&domain{}, *ownerFlag)
+
+	markers, err := listRecords(api, zone, cloudflare.ListDNSRecordsParams{Type: "TXT"})
+	if err != nil {
+		return err
+	}
+	for _, marker := range markers {
+		if !strings.HasPrefix(marker.Name, ownerPrefix) || strings.Trim(marker.Content, "\"") != content || configured[marker.Name] {
+			continue
+		}
+		// Orphaned domain found, delete all its address records
+		name := strings.TrimPrefix(marker.Name, ownerPrefix)
+		if strings.HasPrefix(name, "wildcard.") {
+			name = "*" + strings.TrimPrefix(name, "wildcard")
+		}
+		for _, kind := range []string{"A", "AAAA"} {
+			recs, err := findRecords(api, zone, name, kind)
+			if err != nil {
+				return err
+			}
+			for _, rec := range recs {
+				if _, err := callAPI(api, "DELETE", "/zones/"+zone+"/dns_records/"+rec.ID, nil); err != nil {
+					return err
+				}
+				logInfof("Pruned leftover record of %s (%s %s)", name, kind, rec.Content)
+			}
+		}
+		if _, err := callAPI(api, "DELETE", "/zones/"+zone+"/dns_records/"+marker.ID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
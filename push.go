@@ -0,0 +1,186 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pushTimeout is the time to wait for the update loop to handle a pushed address.
+const pushTimeout = time.Minute
+
+// Timeouts of the HTTP servers (push receiver and status endpoints), so slow or
+// stalled clients cannot hog connections indefinitely.
+const (
+	serverHeaderTimeout = 10 * time.Second // Time allowed to read the request headers
+	serverReadTimeout   = 30 * time.Second // Time allowed to read the entire request
+)
+
+// pushRequest is a set of addresses pushed by a router (or any other client) for
+// the update loop to publish immediately.
+type pushRequest struct {
+	uplink    string        // Interface of the uplink to update (empty for the default route)
+	addresses []string      // Validated addresses to publish
+	result    chan []string // Channel to deliver the per address outcome on
+}
+
+// startPushServer starts an HTTP server accepting addresses pushed to /update in
+// the form of /update?ip=1.2.3.4 (or myip=, with multiple addresses separated by
+// commas), authorized via a bearer token, a basic auth password or a token query
+// parameter. The requests are forwarded to the update loop via the returned
+// channel. POST requests to /trigger (authorized the same way) request an
+// immediate resolution and update round instead.
+func startPushServer(addr string, token string) (<-chan *pushRequest, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pushes := make(chan *pushRequest)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
+		// Make sure the client is authorized to push addresses
+		if !pushAuthorized(r, token) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cloudflare-dyndns"`)
+			http.Error(w, "badauth", http.StatusUnauthorized)
+			return
+		}
+		// Gather and validate all the pushed addresses
+		query := r.URL.Query()
+
+		var addresses []string
+		for _, list := range append(query["ip"], query["myip"]...) {
+			for _, address := range splitList(list) {
+				ip := net.ParseIP(address)
+				if ip == nil {
+					http.Error(w, "badip "+address, http.StatusBadRequest)
+					return
+				}
+				if !*bogonsFlag {
+					if err := checkBogon(ip); err != nil {
+						http.Error(w, "badip "+address, http.StatusBadRequest)
+						return
+					}
+				}
+				addresses = append(addresses, ip.String())
+			}
+		}
+		if len(addresses) == 0 {
+			http.Error(w, "noip", http.StatusBadRequest)
+			return
+		}
+		// Forward the addresses to the update loop and wait for the outcome
+		push := &pushRequest{
+			uplink:    query.Get("uplink"),
+			addresses: addresses,
+			result:    make(chan []string, 1),
+		}
+		timeout := time.After(pushTimeout)
+		select {
+		case pushes <- push:
+		case <-timeout:
+			http.Error(w, "timeout", http.StatusServiceUnavailable)
+			return
+		}
+		select {
+		case outcome := <-push.result:
+			fmt.Fprintln(w, strings.Join(outcome, "\n"))
+		case <-timeout:
+			http.Error(w, "timeout", http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/trigger", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !pushAuthorized(r, token) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cloudflare-dyndns"`)
+			http.Error(w, "badauth", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		if triggerUpdate("control endpoint call from " + r.RemoteAddr) {
+			fmt.Fprintln(w, "queued")
+		} else {
+			fmt.Fprintln(w, "pending")
+		}
+	})
+	go func() {
+		server := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: serverHeaderTimeout,
+			ReadTimeout:       serverReadTimeout,
+		}
+		if err := server.Serve(listener); err != nil {
+			logErrorf("Push receiver failed: %v", err)
+		}
+	}()
+	return pushes, nil
+}
+
+// pushAuthorized checks whether a push request carries the authorization token,
+// either as a bearer token, a basic auth password or a token query parameter.
+func pushAuthorized(r *http.Request, token string) bool {
+	var provided string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	} else if _, password, ok := r.BasicAuth(); ok {
+		provided = password
+	} else {
+		provided = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// handlePush publishes the addresses of a push request on the requested uplink,
+// returning a DynDNS style outcome (good, nochg or an error) for each address.
+// An unchanged address is only reported as such if all the domains and targets
+// are up to date, otherwise the pending ones are retried.
+func handlePush(uplinks []*uplink, push *pushRequest, suffixes map[string]net.IP) []string {
+	var target *uplink
+	for _, uplink := range uplinks {
+		if uplink.iface == push.uplink {
+			target = uplink
+			break
+		}
+	}
+	outcome := make([]string, 0, len(push.addresses))
+	for _, address := range push.addresses {
+		if target == nil {
+			outcome = append(outcome, "nohost "+address)
+			continue
+		}
+		var family *family
+		for _, f := range target.families {
+			if f.ipv6 == (net.ParseIP(address).To4() == nil) {
+				family = f
+			}
+		}
+		switch {
+		case family == nil:
+			outcome = append(outcome, "nofamily "+address)
+		case family.previous == address && len(family.pending) == 0:
+			outcome = append(outcome, "nochg "+address)
+		default:
+			logInfof("Received pushed %s address %s", family, address)
+			family.cached, family.resolved = address, time.Now()
+
+			if publish(target, family, address, suffixes) && len(family.pending) == 0 {
+				outcome = append(outcome, "good "+address)
+			} else {
+				outcome = append(outcome, "911 "+address)
+			}
+		}
+	}
+	return outcome
+}
@@ -0,0 +1,580 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ipv4Resolvers and ipv6Resolvers are the default third party services used to
+// resolve the external address of the machine for each address family.
+var (
+	ipv4Resolvers = []string{"http://ipv4bot.whatismyipaddress.com", "https://api.ipify.org"}
+	ipv6Resolvers = []string{"http://ipv6bot.whatismyipaddress.com", "https://api6.ipify.org"}
+)
+
+// builtinResolvers are shorthand names for well known resolution services, mapped
+// to their IPv4 and IPv6 endpoints.
+var builtinResolvers = map[string][2]string{
+	"cloudflare": {"https://1.1.1.1/cdn-cgi/trace#ip=", "https://[2606:4700:4700::1111]/cdn-cgi/trace#ip="},
+}
+
+// expandResolvers replaces any builtin resolver shorthands in the list with the
+// endpoint for the requested address family.
+func expandResolvers(resolvers []string, ipv6 bool) []string {
+	expanded := make([]string, 0, len(resolvers))
+	for _, resolver := range resolvers {
+		if endpoints, ok := builtinResolvers[resolver]; ok {
+			if ipv6 {
+				resolver = endpoints[1]
+			} else {
+				resolver = endpoints[0]
+			}
+		}
+		expanded = append(expanded, resolver)
+	}
+	return expanded
+}
+
+// dialFunc is a network dialer used to reach the resolution services.
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// newResolverDialer creates a dialer for reaching resolution services with the
+// given connection timeout. If an interface is specified, all connections are
+// sent out from its current address of the requested family, allowing multi-WAN
+// setups to resolve the external address of each uplink.
+func newResolverDialer(iface string, ipv6 bool, connect time.Duration) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialer := &net.Dialer{
+			Timeout:   connect,
+			KeepAlive: 30 * time.Second,
+		}
+		if iface != "" {
+			// Look up the current address of the interface, it may be dynamic too
+			local, err := queryLocal(iface, "", ipv6)
+			if err != nil {
+				return nil, err
+			}
+			ip := net.ParseIP(local)
+			if strings.HasPrefix(network, "udp") {
+				dialer.LocalAddr = &net.UDPAddr{IP: ip}
+			} else {
+				dialer.LocalAddr = &net.TCPAddr{IP: ip}
+			}
+			// Force the network family matching the source address
+			if network == "tcp" || network == "udp" {
+				if ipv6 {
+					network += "6"
+				} else {
+					network += "4"
+				}
+			}
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+}
+
+// newResolverClient creates an HTTP client for querying resolution services with
+// the given dialer, read timeout, TLS configuration and proxy selector.
+func newResolverClient(dial dialFunc, connect, read time.Duration, config *tls.Config, proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	transport := &http.Transport{
+		TLSClientConfig:       config,
+		Proxy:                 proxy,
+		DialContext:           dial,
+		TLSHandshakeTimeout:   connect,
+		ResponseHeaderTimeout: read,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   connect + read,
+	}
+}
+
+// resolverHeaders are the custom HTTP headers to send to resolution services,
+// keyed by the hostname of the service.
+var resolverHeaders = make(map[string]http.Header)
+
+// parseResolverHeaders parses a list of custom resolver headers of the form
+// host=Header: value, grouping them by hostname.
+func parseResolverHeaders(specs []string) (map[string]http.Header, error) {
+	headers := make(map[string]http.Header)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid header, expected host=Header: value: %s", spec)
+		}
+		field := strings.SplitN(parts[1], ":", 2)
+		if len(field) != 2 || strings.TrimSpace(field[0]) == "" {
+			return nil, fmt.Errorf("invalid header, expected host=Header: value: %s", spec)
+		}
+		host := strings.ToLower(strings.TrimSpace(parts[0]))
+		if headers[host] == nil {
+			headers[host] = make(http.Header)
+		}
+		headers[host].Add(strings.TrimSpace(field[0]), strings.TrimSpace(field[1]))
+	}
+	return headers, nil
+}
+
+// newResolverTLSConfig creates the TLS configuration to verify the resolution
+// services with. If a CA file is given, only certificates issued by its CAs are
+// accepted. If pins are given, any connection without a certificate in its chain
+// matching one of the base64 encoded SHA256 SPKI hashes is rejected.
+func newResolverTLSConfig(caFile string, pins []string) (*tls.Config, error) {
+	config := new(tls.Config)
+	if caFile != "" {
+		blob, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(blob) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if len(pins) > 0 {
+		accepted := make(map[string]bool)
+		for _, pin := range pins {
+			if hash, err := base64.StdEncoding.DecodeString(pin); err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("invalid SPKI pin: %s", pin)
+			}
+			accepted[pin] = true
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, chain := range state.VerifiedChains {
+				for _, cert := range chain {
+					hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					if accepted[base64.StdEncoding.EncodeToString(hash[:])] {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("no pinned public key in certificate chain of %s", state.ServerName)
+		}
+	}
+	return config, nil
+}
+
+// filterHTTPS drops all resolution services not reachable via HTTPS, logging a
+// warning about each one discarded.
+func filterHTTPS(resolvers []string) []string {
+	var secure []string
+	for _, resolver := range resolvers {
+		if strings.HasPrefix(strings.ToLower(resolver), "https://") {
+			secure = append(secure, resolver)
+			continue
+		}
+		logWarnf("Ignoring insecure resolver %s", resolver)
+	}
+	return secure
+}
+
+// resolutionMethods are the names of the supported resolution methods that can
+// be used in a resolution chain.
+var resolutionMethods = map[string]bool{
+	"services": true,
+	"upnp":     true,
+	"natpmp":   true,
+	"pcp":      true,
+	"fritzbox": true,
+	"mikrotik": true,
+	"command":  true,
+	"local":    true,
+}
+
+// parseChain parses a comma separated resolution chain of method names and
+// individual resolver URLs in priority order. Unless the chain publishes a
+// local address, the configured resolution services are appended as the last
+// fallback if not explicitly listed.
+func parseChain(chain string) ([]string, error) {
+	var (
+		methods  = splitList(chain)
+		services bool
+		local    bool
+	)
+	for _, method := range methods {
+		switch {
+		case resolutionMethods[method]:
+			services = services || method == "services"
+			local = local || method == "local"
+
+		case builtinResolvers[method] != [2]string{}:
+		case strings.Contains(method, ":"):
+			if _, err := url.Parse(method); err != nil {
+				return nil, fmt.Errorf("invalid resolver %q: %v", method, err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown resolution method: %s", method)
+		}
+	}
+	if !services && !local {
+		methods = append(methods, "services")
+	}
+	return methods, nil
+}
+
+// resolveExternal resolves the external IP address of the machine by trying the
+// methods of a resolution chain in priority order, falling back to the next one
+// whenever a method fails. A method may be the name of a local source (gateway
+// protocols, commands, or the machine's own interfaces in local mode), a single
+// resolver URL, or "services" for the quorum of the configured services. Most
+// gateway protocols only support IPv4 and are skipped for IPv6 resolution.
+func resolveExternal(chain []string, f *family, quorum int) (string, error) {
+	var err error
+	for _, method := range chain {
+		if aborting.Err() != nil {
+			return "", errors.New("resolution aborted on shutdown")
+		}
+		var address string
+		switch {
+		case method == "services":
+			address, err = resolveAddress(f, quorum)
+		case method == "local":
+			address, err = queryLocal(*interfaceFlag, *subnetFlag, f.ipv6)
+		case !resolutionMethods[method]:
+			address, err = querySingle(f, expandResolvers([]string{method}, f.ipv6)[0])
+		case !f.ipv6 || gatewayIPv6[method]:
+			address, err = queryGateway(method, f.iface, f.ipv6)
+		default:
+			continue
+		}
+		if err == nil {
+			if address, err = parseAddress(address, f.ipv6); err == nil {
+				if method == "local" || *bogonsFlag {
+					logDebugf("Resolved %s address %s via %s", f, address, method)
+					return address, nil
+				}
+				if err = checkBogon(net.ParseIP(address)); err == nil {
+					logDebugf("Resolved %s address %s via %s", f, address, method)
+					return address, nil
+				}
+			}
+		}
+		err = fmt.Errorf("%s: %v", method, err)
+		if method != chain[len(chain)-1] {
+			logWarnf("Failed to resolve address, falling back: %v", err)
+		}
+	}
+	if err == nil {
+		err = errors.New("no resolution method available")
+	}
+	return "", err
+}
+
+// querySingle resolves the external IP address of the machine via a single
+// resolution service used as a standalone step of a resolution chain.
+func querySingle(f *family, resolver string) (string, error) {
+	ctx, cancel := context.WithTimeout(aborting, *deadlineFlag)
+	defer cancel()
+
+	start := time.Now()
+	address, err := queryRetrying(ctx, f, resolver)
+	health.record(resolver, time.Since(start), err)
+	debugResolution(resolver, address, time.Since(start), err)
+
+	return address, err
+}
+
+// gatewayIPv6 is the set of local resolution methods supporting IPv6 addresses.
+var gatewayIPv6 = map[string]bool{
+	"fritzbox": true,
+	"mikrotik": true,
+	"command":  true,
+}
+
+// queryGateway retrieves the external IP address of the machine from the local
+// network gateway via the requested method, on the given uplink interface (empty
+// for the default route).
+func queryGateway(method string, iface string, ipv6 bool) (string, error) {
+	switch method {
+	case "upnp":
+		return queryUPnP()
+	case "natpmp":
+		return queryNATPMP()
+	case "pcp":
+		return queryPCP()
+	case "fritzbox":
+		return queryFritzBox(iface, ipv6)
+	case "mikrotik":
+		return queryMikroTik(*mikrotikFlag, ipv6)
+	case "command":
+		return queryCommand(*commandFlag, ipv6)
+	default:
+		return "", fmt.Errorf("unsupported resolution method: %q", method)
+	}
+}
+
+// resolveAddress tries to resolve the external IP address of the machine via
+// third party resolution services. The DNS entry is only updated if at least
+// quorum of them agree on the address (or all of them if the quorum is not
+// positive).
+//
+// The services are queried concurrently, healthy ones first: exactly as many are
+// started as are needed for the quorum, and a new one is started in place of any
+// that fails or disagrees. All queries share a common deadline.
+func resolveAddress(f *family, quorum int) (string, error) {
+	if quorum <= 0 || quorum > len(f.resolvers) {
+		quorum = len(f.resolvers)
+	}
+	ctx, cancel := context.WithTimeout(aborting, *deadlineFlag)
+	defer cancel()
+
+	type result struct {
+		resolver string
+		address  string
+		err      error
+	}
+	var (
+		pending  = health.order(f.resolvers)
+		results  = make(chan result, len(pending))
+		active   int
+		votes    = make(map[string]int)
+		failures []string
+	)
+	query := func(resolver string) {
+		start := time.Now()
+		address, err := queryRetrying(ctx, f, resolver)
+		if err == nil {
+			address, err = parseAddress(address, f.ipv6)
+		}
+		if err == nil && !*bogonsFlag {
+			err = checkBogon(net.ParseIP(address))
+		}
+		health.record(resolver, time.Since(start), err)
+		debugResolution(resolver, address, time.Since(start), err)
+		results <- result{resolver, address, err}
+	}
+	for ; active < quorum; active++ {
+		go query(pending[0])
+		pending = pending[1:]
+	}
+	for active > 0 {
+		select {
+		case res := <-results:
+			active--
+			if res.err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", res.resolver, res.err))
+			} else {
+				votes[res.address]++
+			}
+		case <-ctx.Done():
+			return "", fmt.Errorf("resolution timed out: %v, failures: %v", votes, failures)
+		}
+		// Stop as soon as the quorum is reached or became unreachable
+		best, count, tied := tallyVotes(votes)
+		if count >= quorum && !tied {
+			return best, nil
+		}
+		if count+active+len(pending) < quorum {
+			break
+		}
+		// Quorum still reachable, make sure enough queries are in flight
+		for ; count+active < quorum && len(pending) > 0; active++ {
+			go query(pending[0])
+			pending = pending[1:]
+		}
+	}
+	if len(votes) > 1 {
+		return "", fmt.Errorf("resolution conflict: %v, failures: %v", votes, failures)
+	}
+	return "", fmt.Errorf("quorum of %d not reached: %v, failures: %v", quorum, votes, failures)
+}
+
+// debugResolution logs the outcome of querying a single resolution service.
+func debugResolution(resolver string, address string, elapsed time.Duration, err error) {
+	if endpoint, perr := url.Parse(resolver); perr == nil {
+		resolver = redactURL(endpoint)
+	}
+	if err != nil {
+		logDebugf("Resolver %s failed after %v: %v", resolver, elapsed, err)
+	} else {
+		logDebugf("Resolver %s resolved %s in %v", resolver, address, elapsed)
+	}
+}
+
+// truncateReply shortens the reply of a resolution service for debug logs, as
+// misconfigured services may return entire web pages.
+func truncateReply(reply []byte) string {
+	if len(reply) > 256 {
+		return string(reply[:256]) + "..."
+	}
+	return string(reply)
+}
+
+// tallyVotes returns the address with the most votes, its vote count and whether
+// another address has the same number of votes.
+func tallyVotes(votes map[string]int) (string, int, bool) {
+	var (
+		best  string
+		count int
+		tied  bool
+	)
+	for address, n := range votes {
+		switch {
+		case n > count:
+			best, count, tied = address, n, false
+		case n == count:
+			tied = true
+		}
+	}
+	return best, count, tied
+}
+
+// parseAddress validates that a resolved address is a valid IP address of the
+// requested family, returning it in canonical form.
+func parseAddress(address string, ipv6 bool) (string, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("invalid address: %s", address)
+	}
+	if (ip.To4() == nil) != ipv6 {
+		return "", fmt.Errorf("address family mismatch: %s", ip)
+	}
+	return ip.String(), nil
+}
+
+// queryRetrying queries a single resolution service, retrying failed requests a
+// bounded number of times with exponential backoff.
+func queryRetrying(ctx context.Context, f *family, resolver string) (string, error) {
+	backoff := *backoffFlag
+	for i := 0; ; i++ {
+		address, err := queryResolver(ctx, f, resolver)
+		if err == nil || i >= *retriesFlag {
+			return address, err
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return "", err
+		}
+	}
+}
+
+// queryResolver retrieves the external IP address of the machine from a single
+// resolution service, picking the protocol based on the scheme of the service.
+// The service is reached through the network path of the address family.
+func queryResolver(ctx context.Context, f *family, resolver string) (string, error) {
+	endpoint, err := url.Parse(resolver)
+	if err != nil {
+		return "", err
+	}
+	switch endpoint.Scheme {
+	case "http", "https":
+		return queryHTTP(ctx, f.client, endpoint)
+	case "stun":
+		return querySTUN(ctx, f.dial, endpoint.Opaque, f.ipv6)
+	case "dns":
+		return queryDNS(ctx, f.dial, endpoint, f.ipv6)
+	default:
+		return "", fmt.Errorf("unsupported resolver scheme: %q", endpoint.Scheme)
+	}
+}
+
+// queryHTTP retrieves the external IP address of the machine from an HTTP(S)
+// resolution service. By default the service is expected to reply in plain
+// text, but if the endpoint has a fragment starting with a dot (e.g. #.ip), the
+// reply is parsed as JSON and the address extracted from the given field path.
+// Similarly, if the fragment ends with an equal sign (e.g. #ip=), the reply is
+// parsed as key=value lines and the address extracted from the given key.
+func queryHTTP(ctx context.Context, client *http.Client, endpoint *url.URL) (string, error) {
+	path := endpoint.Fragment
+
+	target := *endpoint
+	target.Fragment = ""
+
+	req, err := http.NewRequest("GET", target.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", *userAgentFlag)
+	for key, values := range resolverHeaders[strings.ToLower(target.Hostname())] {
+		req.Header[key] = values
+	}
+	reply, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", reply.Status)
+	}
+	address, err := ioutil.ReadAll(reply.Body)
+	if err != nil {
+		return "", err
+	}
+	logDebugf("Resolver %s replied %s: %q", redactURL(&target), reply.Status, truncateReply(address))
+	switch {
+	case strings.HasPrefix(path, "."):
+		return extractJSON(address, path)
+	case strings.HasSuffix(path, "="):
+		return extractKeyValue(address, path)
+	}
+	return string(bytes.TrimSpace(address)), nil
+}
+
+// extractJSON extracts a string field from a JSON document along a dot separated
+// path of object keys and array indices (e.g. .ip or .result.0.address).
+func extractJSON(blob []byte, path string) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(blob, &value); err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("json field %q not found", key)
+			}
+			value = child
+
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", fmt.Errorf("json index %q out of bounds", key)
+			}
+			value = node[index]
+
+		default:
+			return "", fmt.Errorf("json field %q not traversable", key)
+		}
+	}
+	address, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("json field %s is not a string", path)
+	}
+	return strings.TrimSpace(address), nil
+}
+
+// extractKeyValue extracts a value from a document of key=value lines, such as
+// the trace endpoint of CloudFlare, where the prefix is the key with the equal
+// sign attached (e.g. ip=).
+func extractKeyValue(blob []byte, prefix string) (string, error) {
+	for _, line := range strings.Split(string(blob), "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(line, prefix)), nil
+		}
+	}
+	return "", fmt.Errorf("key %q not found", strings.TrimSuffix(prefix, "="))
+}
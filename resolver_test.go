@@ -0,0 +1,129 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Tests that the votes of the resolution services are tallied correctly.
+func TestTallyVotes(t *testing.T) {
+	tests := []struct {
+		votes map[string]int
+		best  string
+		count int
+		tied  bool
+	}{
+		{votes: map[string]int{}, best: "", count: 0},
+		{votes: map[string]int{"1.1.1.1": 1}, best: "1.1.1.1", count: 1},
+		{votes: map[string]int{"1.1.1.1": 3, "2.2.2.2": 1}, best: "1.1.1.1", count: 3},
+		{votes: map[string]int{"1.1.1.1": 1, "2.2.2.2": 3, "3.3.3.3": 2}, best: "2.2.2.2", count: 3},
+		{votes: map[string]int{"1.1.1.1": 2, "2.2.2.2": 2}, count: 2, tied: true},
+		{votes: map[string]int{"1.1.1.1": 2, "2.2.2.2": 2, "3.3.3.3": 3}, best: "3.3.3.3", count: 3},
+	}
+	for i, tt := range tests {
+		best, count, tied := tallyVotes(tt.votes)
+		if count != tt.count || tied != tt.tied || (!tt.tied && best != tt.best) {
+			t.Errorf("test %d: tally mismatch: have %s/%d/%v, want %s/%d/%v", i, best, count, tied, tt.best, tt.count, tt.tied)
+		}
+	}
+}
+
+// Tests that the external address is only accepted if a quorum of the resolution
+// services agree on it.
+func TestResolveAddress(t *testing.T) {
+	// Each reply is either an address to report or a failure. Failures are not
+	// reported via HTTP errors, as those would be retried with a backoff.
+	tests := []struct {
+		replies []string
+		quorum  int
+		want    string
+		fail    string
+	}{
+		{replies: []string{"8.8.8.8", "8.8.8.8", "8.8.8.8"}, quorum: 0, want: "8.8.8.8"},
+		{replies: []string{"8.8.8.8", "8.8.8.8", "fail"}, quorum: 0, fail: "quorum of 3 not reached"},
+		{replies: []string{"8.8.8.8", "8.8.4.4", "8.8.8.8"}, quorum: 0, fail: "resolution conflict"},
+		{replies: []string{"8.8.8.8", "fail", "8.8.8.8"}, quorum: 2, want: "8.8.8.8"},
+		{replies: []string{"fail", "fail", "8.8.8.8", "8.8.8.8"}, quorum: 2, want: "8.8.8.8"},
+		{replies: []string{"8.8.8.8", "8.8.4.4", "8.8.8.8", "8.8.4.4"}, quorum: 2, want: ""},
+		{replies: []string{"8.8.8.8", "fail", "fail"}, quorum: 2, fail: "quorum of 2 not reached"},
+		{replies: []string{"8.8.8.8", "8.8.8.8"}, quorum: 5, want: "8.8.8.8"},
+		{replies: []string{"8.8.8.8", "10.0.0.1", "8.8.8.8"}, quorum: 0, fail: "bogon"},
+		{replies: []string{"8.8.8.8", "2001:4860:4860::8888", "8.8.8.8"}, quorum: 0, fail: "family mismatch"},
+		{replies: []string{"8.8.8.8", "garbage", "8.8.8.8"}, quorum: 0, fail: "invalid address"},
+	}
+	for i, tt := range tests {
+		var resolvers []string
+		for _, reply := range tt.replies {
+			reply := reply
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if reply == "fail" {
+					reply = "service unavailable"
+				}
+				fmt.Fprintln(w, reply)
+			}))
+			defer server.Close()
+			resolvers = append(resolvers, server.URL)
+		}
+		address, err := resolveAddress(newFamily("", false, resolvers, nil, nil), tt.quorum)
+		switch {
+		case tt.fail != "":
+			if err == nil || !strings.Contains(err.Error(), tt.fail) {
+				t.Errorf("test %d: error mismatch: have %v, want %q", i, err, tt.fail)
+			}
+		case tt.want == "":
+			// Either address may win the race to the quorum
+			if err != nil || (address != "8.8.8.8" && address != "8.8.4.4") {
+				t.Errorf("test %d: resolution mismatch: have %s/%v, want either address", i, address, err)
+			}
+		default:
+			if err != nil || address != tt.want {
+				t.Errorf("test %d: resolution mismatch: have %s/%v, want %s", i, address, err, tt.want)
+			}
+		}
+	}
+}
+
+// Tests that addresses are extracted from JSON replies along field paths.
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		blob string
+		path string
+		want string
+		fail bool
+	}{
+		{blob: `{"ip":"1.2.3.4"}`, path: ".ip", want: "1.2.3.4"},
+		{blob: `{"ip":" 1.2.3.4\n"}`, path: ".ip", want: "1.2.3.4"},
+		{blob: `{"result":{"address":"1.2.3.4"}}`, path: ".result.address", want: "1.2.3.4"},
+		{blob: `{"result":[{"address":"1.2.3.4"},{"address":"5.6.7.8"}]}`, path: ".result.1.address", want: "5.6.7.8"},
+		{blob: `["1.2.3.4"]`, path: ".0", want: "1.2.3.4"},
+		{blob: `{"ip":"1.2.3.4"}`, path: ".addr", fail: true},
+		{blob: `{"ip":4}`, path: ".ip", fail: true},
+		{blob: `{"ip":{"v4":"1.2.3.4"}}`, path: ".ip", fail: true},
+		{blob: `{"ip":"1.2.3.4"}`, path: ".ip.v4", fail: true},
+		{blob: `["1.2.3.4"]`, path: ".1", fail: true},
+		{blob: `["1.2.3.4"]`, path: ".-1", fail: true},
+		{blob: `["1.2.3.4"]`, path: ".first", fail: true},
+		{blob: `1.2.3.4`, path: ".ip", fail: true},
+		{blob: `{"ip":`, path: ".ip", fail: true},
+	}
+	for _, tt := range tests {
+		address, err := extractJSON([]byte(tt.blob), tt.path)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s %s: expected failure, got %q", tt.blob, tt.path, address)
+			}
+			continue
+		}
+		if err != nil || address != tt.want {
+			t.Errorf("%s %s: extraction mismatch: have %q/%v, want %q", tt.blob, tt.path, address, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,598 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS protocol constants needed to assemble queries and dynamic updates.
+const (
+	dnsOpcodeQuery  = 0   // Standard query opcode
+	dnsOpcodeUpdate = 5   // Dynamic update opcode (RFC 2136)
+	dnsTypeA        = 1   // IPv4 address record type
+	dnsTypeSOA      = 6   // Start of authority record type (zone section)
+	dnsTypeAAAA     = 28  // IPv6 address record type
+	dnsTypeTSIG     = 250 // Transaction signature record type (RFC 8945)
+	dnsClassIN      = 1   // Internet class
+	dnsClassAny     = 255 // Any class, deleting a whole record set in updates
+	dnsTSIGFudge    = 300 // Permitted clock skew of signed messages in seconds
+)
+
+// dnsRcodes are the textual forms of the DNS response codes relevant to updates.
+var dnsRcodes = map[int]string{
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+}
+
+// tsigAlgorithms are the supported TSIG HMAC algorithms, by their short names.
+var tsigAlgorithms = map[string]struct {
+	name string           // Algorithm name used on the wire
+	hash func() hash.Hash // Hash function of the HMAC
+}{
+	"hmac-md5":    {"hmac-md5.sig-alg.reg.int", md5.New},
+	"hmac-sha1":   {"hmac-sha1", sha1.New},
+	"hmac-sha224": {"hmac-sha224", sha256.New224},
+	"hmac-sha256": {"hmac-sha256", sha256.New},
+	"hmac-sha384": {"hmac-sha384", sha512.New384},
+	"hmac-sha512": {"hmac-sha512", sha512.New},
+}
+
+// tsigKey is a shared secret to sign DNS messages with.
+type tsigKey struct {
+	name      string           // Name of the key, as configured on the server
+	algorithm string           // Algorithm name used on the wire
+	hash      func() hash.Hash // Hash function of the HMAC
+	secret    []byte           // Shared secret of the HMAC
+}
+
+// parseTSIGKey parses a TSIG key in nsupdate's [algorithm:]name:secret format,
+// where the secret is base64 encoded and the algorithm defaults to hmac-sha256.
+func parseTSIGKey(spec string) (*tsigKey, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) == 2 {
+		parts = append([]string{"hmac-sha256"}, parts...)
+	}
+	if len(parts) != 3 {
+		return nil, errors.New("invalid TSIG key, expected [algorithm:]name:secret")
+	}
+	alg, ok := tsigAlgorithms[strings.ToLower(parts[0])]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TSIG algorithm: %s", parts[0])
+	}
+	secret, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG secret: %v", err)
+	}
+	return &tsigKey{
+		name:      strings.ToLower(strings.TrimSuffix(parts[1], ".")),
+		algorithm: alg.name,
+		hash:      alg.hash,
+		secret:    secret,
+	}, nil
+}
+
+// rfc2136Provider is a generic dynamic DNS update provider (RFC 2136), updating
+// the records of any authoritative server accepting them (e.g. BIND, Knot or
+// PowerDNS), optionally authenticated via TSIG.
+type rfc2136Provider struct {
+	server string   // Address of the authoritative server accepting updates
+	key    *tsigKey // Key to sign messages with (nil = unsigned)
+}
+
+// newRFC2136Provider creates a dynamic DNS update provider for the given server
+// and TSIG key (empty = unsigned).
+func newRFC2136Provider(server string, key string) (*rfc2136Provider, error) {
+	if server == "" {
+		return nil, errors.New("no server specified, use -rfc2136-server")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), dnsDefaultPort)
+	}
+	p := &rfc2136Provider{server: server}
+	if key != "" {
+		var err error
+		if p.key, err = parseTSIGKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *rfc2136Provider) String() string {
+	return "rfc2136"
+}
+
+// verify implements provider, checking that the server is authoritative for the
+// zones of all domains and accepts the key (if any). Whether updates are allowed
+// can only be seen when the first one is attempted.
+func (p *rfc2136Provider) verify(domains []*domain) error {
+	for _, host := range domains {
+		zone, err := hostZone(host)
+		if err != nil {
+			return err
+		}
+		if _, _, err := p.query(zone, dnsTypeSOA); err != nil {
+			if strings.Contains(err.Error(), "REFUSED") || strings.Contains(err.Error(), "NOTAUTH") || strings.Contains(err.Error(), "TSIG") {
+				return fmt.Errorf("zone %s not accessible on %s: %v", zone, p.server, err)
+			}
+			logErrorf("Failed to verify zone of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record set of a domain to hold the
+// given address, according to the configured policy for multiple records. The
+// current records are queried from the server first, so unchanged ones are not
+// updated needlessly.
+func (p *rfc2136Provider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = hostZone(host); err != nil {
+			return false, err
+		}
+	}
+	rtype := uint16(dnsTypeA)
+	if kind == "AAAA" {
+		rtype = dnsTypeAAAA
+	}
+	values, current, err := p.query(host.name, rtype)
+	if err != nil {
+		return false, fmt.Errorf("record retrieval failed: %v", err)
+	}
+	if len(values) == 0 && !*createFlag {
+		return false, fmt.Errorf("invalid number of DNS records found: %v", values)
+	}
+	// Assemble the new record set, servers having no notion of automatic TTLs
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl == 1 {
+		ttl = 300
+	}
+	merged, err := mergeValues(values, address, previous)
+	if err != nil {
+		return false, err
+	}
+	if len(values) > 0 && int(current) == ttl && strings.Join(values, ",") == strings.Join(merged, ",") {
+		return false, nil
+	}
+	// Replace the whole record set atomically, requiring it to exist unless new
+	// records may be created
+	msg := newDNSMessage(dnsOpcodeUpdate)
+	msg.question(host.zoneID, dnsTypeSOA)
+	if !*createFlag {
+		msg.prerequisites++
+		msg.record(host.name, rtype, dnsClassAny, 0, nil)
+	}
+	msg.updates++
+	msg.record(host.name, rtype, dnsClassAny, 0, nil)
+	for _, value := range merged {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return false, fmt.Errorf("invalid address: %s", value)
+		}
+		if kind == "A" {
+			ip = ip.To4()
+		}
+		msg.updates++
+		msg.record(host.name, rtype, dnsClassIN, uint32(ttl), ip)
+	}
+	if *dryRunFlag {
+		logInfof("Dry run, not sending: UPDATE %s %s %s -> %v (ttl %d)", p.server, host, kind, merged, ttl)
+		return true, nil
+	}
+	if _, err := p.exchange(msg); err != nil {
+		if strings.Contains(err.Error(), "NXRRSET") {
+			return false, fmt.Errorf("no %s records found for %s, use -create to add them", kind, host)
+		}
+		return false, fmt.Errorf("dns update failed: %v", err)
+	}
+	if len(values) == 0 {
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+	}
+	return true, nil
+}
+
+// query retrieves the addresses and TTL of the given record set directly from
+// the authoritative server.
+func (p *rfc2136Provider) query(name string, rtype uint16) ([]string, uint32, error) {
+	msg := newDNSMessage(dnsOpcodeQuery)
+	msg.question(name, rtype)
+
+	reply, err := p.exchange(msg)
+	if err != nil {
+		if strings.Contains(err.Error(), "NXDOMAIN") {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	var (
+		values []string
+		ttl    uint32
+	)
+	for _, rr := range reply.answers {
+		if rr.rtype != rtype || !strings.EqualFold(rr.name, name) {
+			continue
+		}
+		if (rtype == dnsTypeA && len(rr.data) != net.IPv4len) || (rtype == dnsTypeAAAA && len(rr.data) != net.IPv6len) {
+			return nil, 0, fmt.Errorf("invalid %d byte address record", len(rr.data))
+		}
+		if rtype == dnsTypeA || rtype == dnsTypeAAAA {
+			values = append(values, net.IP(rr.data).String())
+		}
+		ttl = rr.ttl
+	}
+	return values, ttl, nil
+}
+
+// exchange signs a DNS message (if a key is configured), sends it to the server
+// over TCP and returns the parsed reply, verifying its signature and rcode.
+func (p *rfc2136Provider) exchange(msg *dnsMessage) (*dnsReply, error) {
+	var (
+		packet = msg.bytes()
+		mac    []byte
+	)
+	if p.key != nil {
+		packet, mac = p.key.sign(packet, uint64(time.Now().Unix()))
+	}
+	conn, err := net.DialTimeout("tcp", p.server, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	// DNS over TCP prefixes every message with its length
+	frame := make([]byte, 2, 2+len(packet))
+	binary.BigEndian.PutUint16(frame, uint16(len(packet)))
+	if _, err := conn.Write(append(frame, packet...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, frame[:2]); err != nil {
+		return nil, err
+	}
+	res := make([]byte, binary.BigEndian.Uint16(frame[:2]))
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return nil, err
+	}
+	reply, err := parseDNSReply(res)
+	if err != nil {
+		return nil, err
+	}
+	if reply.id != msg.id {
+		return nil, errors.New("mismatching reply id")
+	}
+	if rcode := reply.rcode; rcode != 0 {
+		if name, ok := dnsRcodes[rcode]; ok {
+			return nil, fmt.Errorf("server replied %s", name)
+		}
+		return nil, fmt.Errorf("server replied rcode %d", rcode)
+	}
+	// Successful replies to signed requests must be signed with the same key
+	if p.key != nil {
+		if err := p.key.verify(res, reply, mac); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// dnsMessage is an outgoing DNS message being assembled. For updates, the query
+// counters of the header are reinterpreted as the section sizes of RFC 2136.
+type dnsMessage struct {
+	id     uint16 // Random identifier of the message
+	opcode int    // Operation of the message (query or update)
+
+	questions     uint16 // Number of questions (zones for updates)
+	prerequisites uint16 // Number of answers (prerequisites for updates)
+	updates       uint16 // Number of authority records (updates for updates)
+
+	body []byte // Sections of the message after the header
+}
+
+// newDNSMessage creates an empty DNS message with a random identifier.
+func newDNSMessage(opcode int) *dnsMessage {
+	var id [2]byte
+	rand.Read(id[:])
+	return &dnsMessage{id: binary.BigEndian.Uint16(id[:]), opcode: opcode}
+}
+
+// question appends a question (or the zone of an update) to the message.
+func (m *dnsMessage) question(name string, rtype uint16) {
+	m.questions++
+	m.body = appendDNSName(m.body, name)
+	m.body = appendUint16(m.body, rtype)
+	m.body = appendUint16(m.body, dnsClassIN)
+}
+
+// record appends a resource record to the message. The caller is responsible
+// for counting it in the correct section.
+func (m *dnsMessage) record(name string, rtype uint16, class uint16, ttl uint32, data []byte) {
+	m.body = appendDNSName(m.body, name)
+	m.body = appendUint16(m.body, rtype)
+	m.body = appendUint16(m.body, class)
+	m.body = append(m.body, byte(ttl>>24), byte(ttl>>16), byte(ttl>>8), byte(ttl))
+	m.body = appendUint16(m.body, uint16(len(data)))
+	m.body = append(m.body, data...)
+}
+
+// bytes serializes the DNS message into its wire format.
+func (m *dnsMessage) bytes() []byte {
+	packet := make([]byte, 0, 12+len(m.body))
+	packet = appendUint16(packet, m.id)
+	packet = appendUint16(packet, uint16(m.opcode<<11))
+	packet = appendUint16(packet, m.questions)
+	packet = appendUint16(packet, m.prerequisites)
+	packet = appendUint16(packet, m.updates)
+	packet = appendUint16(packet, 0)
+	return append(packet, m.body...)
+}
+
+// dnsRecord is a resource record parsed from a DNS reply.
+type dnsRecord struct {
+	name   string // Owner name of the record
+	rtype  uint16 // Type of the record
+	class  uint16 // Class of the record
+	ttl    uint32 // Time to live of the record
+	data   []byte // Raw record data
+	offset int    // Offset of the record within the reply
+}
+
+// dnsReply is a parsed DNS reply.
+type dnsReply struct {
+	id      uint16      // Identifier of the request being replied to
+	rcode   int         // Response code of the reply
+	answers []dnsRecord // Records of the answer section
+	extras  []dnsRecord // Records of the additional section
+}
+
+// parseDNSReply parses the wire format of a DNS reply.
+func parseDNSReply(packet []byte) (*dnsReply, error) {
+	if len(packet) < 12 {
+		return nil, errors.New("truncated dns reply")
+	}
+	reply := &dnsReply{
+		id:    binary.BigEndian.Uint16(packet),
+		rcode: int(packet[3] & 0x0f),
+	}
+	var (
+		counts = [4]int{}
+		offset = 12
+		err    error
+	)
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(packet[4+2*i:]))
+	}
+	for i := 0; i < counts[0]; i++ {
+		if _, offset, err = parseDNSName(packet, offset); err != nil {
+			return nil, err
+		}
+		if offset += 4; offset > len(packet) {
+			return nil, errors.New("truncated dns question")
+		}
+	}
+	for section := 1; section < 4; section++ {
+		for i := 0; i < counts[section]; i++ {
+			rr := dnsRecord{offset: offset}
+			if rr.name, offset, err = parseDNSName(packet, offset); err != nil {
+				return nil, err
+			}
+			if offset+10 > len(packet) {
+				return nil, errors.New("truncated dns record")
+			}
+			rr.rtype = binary.BigEndian.Uint16(packet[offset:])
+			rr.class = binary.BigEndian.Uint16(packet[offset+2:])
+			rr.ttl = binary.BigEndian.Uint32(packet[offset+4:])
+			size := int(binary.BigEndian.Uint16(packet[offset+8:]))
+			if offset += 10; offset+size > len(packet) {
+				return nil, errors.New("truncated dns record data")
+			}
+			rr.data, offset = packet[offset:offset+size], offset+size
+
+			switch section {
+			case 1:
+				reply.answers = append(reply.answers, rr)
+			case 3:
+				reply.extras = append(reply.extras, rr)
+			}
+		}
+	}
+	return reply, nil
+}
+
+// parseDNSName parses a possibly compressed domain name from a DNS message,
+// returning it without the trailing root dot, along with the offset after it.
+func parseDNSName(packet []byte, offset int) (string, int, error) {
+	var (
+		labels []string
+		next   = -1
+	)
+	for jumps := 0; ; {
+		if offset >= len(packet) {
+			return "", 0, errors.New("truncated dns name")
+		}
+		size := int(packet[offset])
+		switch {
+		case size == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+
+		case size&0xc0 == 0xc0:
+			if offset+1 >= len(packet) {
+				return "", 0, errors.New("truncated dns name pointer")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("dns name pointer loop")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(packet[offset:]) & 0x3fff)
+
+		case size&0xc0 != 0:
+			return "", 0, errors.New("unsupported dns label type")
+
+		default:
+			if offset+1+size > len(packet) {
+				return "", 0, errors.New("truncated dns label")
+			}
+			labels = append(labels, string(packet[offset+1:offset+1+size]))
+			offset += 1 + size
+		}
+	}
+}
+
+// sign appends a TSIG record to a DNS message, returning the signed message and
+// the MAC needed to verify the reply.
+func (k *tsigKey) sign(packet []byte, now uint64) ([]byte, []byte) {
+	mac := hmac.New(k.hash, k.secret)
+	mac.Write(packet)
+	mac.Write(k.variables(now, dnsTSIGFudge, 0, nil))
+	sum := mac.Sum(nil)
+
+	// Assemble the TSIG record and count it as an additional one
+	var data []byte
+	data = appendDNSName(data, k.algorithm)
+	data = append(data, byte(now>>40), byte(now>>32), byte(now>>24), byte(now>>16), byte(now>>8), byte(now))
+	data = appendUint16(data, dnsTSIGFudge)
+	data = appendUint16(data, uint16(len(sum)))
+	data = append(data, sum...)
+	data = append(data, packet[0], packet[1]) // Original ID
+	data = appendUint16(data, 0)              // Error
+	data = appendUint16(data, 0)              // Other length
+
+	signed := append([]byte{}, packet...)
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	signed = appendDNSName(signed, k.name)
+	signed = appendUint16(signed, dnsTypeTSIG)
+	signed = appendUint16(signed, dnsClassAny)
+	signed = append(signed, 0, 0, 0, 0)
+	signed = appendUint16(signed, uint16(len(data)))
+	signed = append(signed, data...)
+
+	return signed, sum
+}
+
+// verify checks the TSIG record of a reply against the MAC of its request.
+func (k *tsigKey) verify(packet []byte, reply *dnsReply, request []byte) error {
+	if len(reply.extras) == 0 || reply.extras[len(reply.extras)-1].rtype != dnsTypeTSIG {
+		return errors.New("unsigned reply to TSIG signed request")
+	}
+	tsig := reply.extras[len(reply.extras)-1]
+	if !strings.EqualFold(tsig.name, k.name) {
+		return fmt.Errorf("reply signed with unknown TSIG key %s", tsig.name)
+	}
+	// Extract the fields from the record, skipping the algorithm
+	_, offset, err := parseDNSName(tsig.data, 0)
+	if err != nil || offset+10 > len(tsig.data) {
+		return errors.New("invalid TSIG record")
+	}
+	var signed uint64
+	for _, b := range tsig.data[offset : offset+6] {
+		signed = signed<<8 | uint64(b)
+	}
+	fudge := binary.BigEndian.Uint16(tsig.data[offset+6:])
+	size := int(binary.BigEndian.Uint16(tsig.data[offset+8:]))
+	if offset+10+size+6 > len(tsig.data) {
+		return errors.New("invalid TSIG record")
+	}
+	have := tsig.data[offset+10 : offset+10+size]
+
+	trailer := tsig.data[offset+10+size:]
+	code := binary.BigEndian.Uint16(trailer[2:])
+	other := int(binary.BigEndian.Uint16(trailer[4:]))
+	if 6+other > len(trailer) {
+		return errors.New("invalid TSIG record")
+	}
+	if code != 0 {
+		if name, ok := dnsRcodes[int(code)]; ok {
+			return fmt.Errorf("TSIG error %s in reply", name)
+		}
+		return fmt.Errorf("TSIG error %d in reply", code)
+	}
+	// Recompute the MAC over the reply without its TSIG record, with the original
+	// message id restored
+	stripped := append([]byte{}, packet[:tsig.offset]...)
+	copy(stripped, trailer[:2])
+	binary.BigEndian.PutUint16(stripped[10:], binary.BigEndian.Uint16(stripped[10:])-1)
+
+	mac := hmac.New(k.hash, k.secret)
+	mac.Write(appendUint16(nil, uint16(len(request))))
+	mac.Write(request)
+	mac.Write(stripped)
+	mac.Write(k.variables(signed, fudge, code, trailer[6:6+other]))
+	if !hmac.Equal(mac.Sum(nil), have) {
+		return errors.New("invalid TSIG signature of reply")
+	}
+	if drift := int64(signed) - time.Now().Unix(); drift > int64(fudge) || drift < -int64(fudge) {
+		return errors.New("TSIG signature of reply outside of time window")
+	}
+	return nil
+}
+
+// variables serializes the TSIG variables covered by the MAC, as defined in
+// RFC 8945, section 4.3.3.
+func (k *tsigKey) variables(now uint64, fudge uint16, code uint16, other []byte) []byte {
+	var vars []byte
+	vars = appendDNSName(vars, k.name)
+	vars = appendUint16(vars, dnsClassAny)
+	vars = append(vars, 0, 0, 0, 0)
+	vars = appendDNSName(vars, k.algorithm)
+	vars = append(vars, byte(now>>40), byte(now>>32), byte(now>>24), byte(now>>16), byte(now>>8), byte(now))
+	vars = appendUint16(vars, fudge)
+	vars = appendUint16(vars, code)
+	vars = appendUint16(vars, uint16(len(other)))
+	return append(vars, other...)
+}
+
+// appendDNSName appends the uncompressed wire format of a domain name.
+func appendDNSName(buf []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0)
+}
+
+// appendUint16 appends a big endian 16 bit integer.
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
@@ -0,0 +1,293 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unhex decodes a hex string, ignoring any spaces used to group the fields.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	blob, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return blob
+}
+
+// Tests that TSIG keys in nsupdate's format are parsed correctly.
+func TestParseTSIGKey(t *testing.T) {
+	tests := []struct {
+		spec      string
+		name      string
+		algorithm string
+		fail      bool
+	}{
+		{spec: "key.example:c2VjcmV0", name: "key.example", algorithm: "hmac-sha256"},
+		{spec: "hmac-sha512:Key.Example.:c2VjcmV0", name: "key.example", algorithm: "hmac-sha512"},
+		{spec: "HMAC-MD5:key:c2VjcmV0", name: "key", algorithm: "hmac-md5.sig-alg.reg.int"},
+		{spec: "hmac-sha1:key:c2VjcmV0", name: "key", algorithm: "hmac-sha1"},
+		{spec: "c2VjcmV0", fail: true},
+		{spec: "a:b:c:d", fail: true},
+		{spec: "hmac-foo:key:c2VjcmV0", fail: true},
+		{spec: "key:not base64!", fail: true},
+	}
+	for _, tt := range tests {
+		key, err := parseTSIGKey(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected failure, got %+v", tt.spec, key)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", tt.spec, err)
+			continue
+		}
+		if key.name != tt.name || key.algorithm != tt.algorithm || string(key.secret) != "secret" {
+			t.Errorf("%s: key mismatch: have %s/%s/%q, want %s/%s/%q", tt.spec, key.name, key.algorithm, key.secret, tt.name, tt.algorithm, "secret")
+		}
+	}
+}
+
+// Tests that messages are signed as specified by RFC 8945, with the MACs being
+// computed independently over the digest components of section 4.3.3.
+func TestTSIGSign(t *testing.T) {
+	message := "1234 2800 0001 0000 0000 0000 07 6578616d706c65 03 636f6d 00 0006 0001" // UPDATE example.com SOA
+
+	tests := []struct {
+		spec  string
+		rdata string
+	}{
+		{
+			spec: "hmac-sha256:key.example:c2VjcmV0",
+			rdata: "0b 686d61632d736861323536 00" + // Algorithm name
+				" 00006553f100 012c" + // Time signed, fudge
+				" 0020 ce2f8405e60ba2bbb599a1652c47434fe7d1af56bfb53a2916ce256f08037254" + // MAC
+				" 1234 0000 0000", // Original id, error, other length
+		},
+		{
+			spec: "hmac-md5:key.example.:c2VjcmV0",
+			rdata: "08 686d61632d6d6435 07 7369672d616c67 03 726567 03 696e74 00" + // Algorithm name
+				" 00006553f100 012c" + // Time signed, fudge
+				" 0010 c1140390a7900422df311a1427cadd3e" + // MAC
+				" 1234 0000 0000", // Original id, error, other length
+		},
+	}
+	for _, tt := range tests {
+		key, err := parseTSIGKey(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: failed to parse key: %v", tt.spec, err)
+		}
+		rdata := unhex(t, tt.rdata)
+		want := unhex(t, strings.Replace(message, "0000 0000 0000 07", "0000 0000 0001 07", 1)) // One additional record
+		want = append(want, unhex(t, "03 6b6579 07 6578616d706c65 00 00fa 00ff 00000000")...)   // key.example TSIG ANY, TTL 0
+		want = appendUint16(want, uint16(len(rdata)))
+		want = append(want, rdata...)
+
+		signed, mac := key.sign(unhex(t, message), 1700000000)
+		if !bytes.Equal(signed, want) {
+			t.Errorf("%s: signed message mismatch:\nhave %x\nwant %x", tt.spec, signed, want)
+		}
+		if size := int(binary.BigEndian.Uint16(rdata[len(rdata)-8-len(mac):])); size != len(mac) || !bytes.Contains(rdata, mac) {
+			t.Errorf("%s: returned MAC %x not the one in the record", tt.spec, mac)
+		}
+		if _, err := parseDNSReply(signed); err != nil {
+			t.Errorf("%s: signed message unparsable: %v", tt.spec, err)
+		}
+	}
+}
+
+// signedReply assembles the reply to an update request, signed per RFC 8945 with
+// the given TSIG parameters, the MAC chaining the one of the request.
+func signedReply(t *testing.T, key *tsigKey, request []byte, now uint64, code uint16, tamper bool) []byte {
+	t.Helper()
+
+	reply := unhex(t, "1234 a800 0001 0000 0000 0000 07 6578616d706c65 03 636f6d 00 0006 0001")
+	vars := unhex(t, "03 6b6579 07 6578616d706c65 00 00ff 00000000 0b 686d61632d736861323536 00")
+	vars = append(vars, byte(now>>40), byte(now>>32), byte(now>>24), byte(now>>16), byte(now>>8), byte(now))
+	vars = append(vars, 0x01, 0x2c, byte(code>>8), byte(code), 0x00, 0x00)
+
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write(appendUint16(nil, uint16(len(request))))
+	mac.Write(request)
+	mac.Write(reply)
+	mac.Write(vars)
+	sum := mac.Sum(nil)
+	if tamper {
+		sum[0] ^= 0xff
+	}
+	rdata := unhex(t, "0b 686d61632d736861323536 00")
+	rdata = append(rdata, vars[len(vars)-12:len(vars)-4]...) // Time signed, fudge
+	rdata = append(rdata, 0x00, byte(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, 0x12, 0x34, byte(code>>8), byte(code), 0x00, 0x00)
+
+	signed := append([]byte{}, reply...)
+	signed[11] = 1
+	signed = append(signed, unhex(t, "03 6b6579 07 6578616d706c65 00 00fa 00ff 00000000")...)
+	signed = appendUint16(signed, uint16(len(rdata)))
+	return append(signed, rdata...)
+}
+
+// Tests that the signatures of replies are verified as specified by RFC 8945.
+func TestTSIGVerify(t *testing.T) {
+	key, err := parseTSIGKey("hmac-sha256:key.example:c2VjcmV0")
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	other, _ := parseTSIGKey("hmac-sha256:other.example:c2VjcmV0")
+
+	var (
+		now     = uint64(time.Now().Unix())
+		request = bytes.Repeat([]byte{0xaa}, 32)
+	)
+	tests := []struct {
+		name    string
+		key     *tsigKey
+		reply   []byte
+		request []byte
+		fail    string
+	}{
+		{name: "valid", key: key, reply: signedReply(t, key, request, now, 0, false), request: request},
+		{name: "tampered", key: key, reply: signedReply(t, key, request, now, 0, true), request: request, fail: "invalid TSIG signature"},
+		{name: "other request", key: key, reply: signedReply(t, key, request[1:], now, 0, false), request: request, fail: "invalid TSIG signature"},
+		{name: "expired", key: key, reply: signedReply(t, key, request, now-1000, 0, false), request: request, fail: "time window"},
+		{name: "tsig error", key: key, reply: signedReply(t, key, request, now, 18, false), request: request, fail: "BADTIME"},
+		{name: "other key", key: other, reply: signedReply(t, key, request, now, 0, false), request: request, fail: "unknown TSIG key"},
+		{name: "unsigned", key: key, reply: unhex(t, "1234 a800 0001 0000 0000 0000 07 6578616d706c65 03 636f6d 00 0006 0001"), request: request, fail: "unsigned"},
+	}
+	for _, tt := range tests {
+		reply, err := parseDNSReply(tt.reply)
+		if err != nil {
+			t.Errorf("%s: failed to parse reply: %v", tt.name, err)
+			continue
+		}
+		err = tt.key.verify(tt.reply, reply, tt.request)
+		switch {
+		case tt.fail == "" && err != nil:
+			t.Errorf("%s: failed to verify: %v", tt.name, err)
+		case tt.fail != "" && (err == nil || !strings.Contains(err.Error(), tt.fail)):
+			t.Errorf("%s: verification error mismatch: have %v, want %q", tt.name, err, tt.fail)
+		}
+	}
+	// Ensure truncated TSIG records are rejected instead of crashing
+	valid := signedReply(t, key, request, now, 0, false)
+	parsed, err := parseDNSReply(valid)
+	if err != nil {
+		t.Fatalf("failed to parse reply: %v", err)
+	}
+	var (
+		prefix = valid[:len(valid)-len(parsed.extras[0].data)]
+		reply  []byte
+	)
+	for size := 0; size < len(valid)-len(prefix); size++ {
+		reply = append(append(reply[:0], prefix...), valid[len(prefix):len(prefix)+size]...)
+		binary.BigEndian.PutUint16(reply[len(prefix)-2:], uint16(size))
+
+		parsed, err := parseDNSReply(reply)
+		if err != nil {
+			t.Errorf("reply with %d byte TSIG record unparsable: %v", size, err)
+			continue
+		}
+		if err := key.verify(reply, parsed, request); err == nil {
+			t.Errorf("truncated %d byte TSIG record accepted", size)
+		}
+	}
+}
+
+// Tests that DNS replies are parsed correctly, including compressed names.
+func TestParseDNSReply(t *testing.T) {
+	packet := unhex(t, "1234 8180 0001 0002 0000 0000"+ // Header: 1 question, 2 answers
+		" 03 777777 07 6578616d706c65 03 636f6d 00 0001 0001"+ // www.example.com A IN
+		" c00c 0001 0001 0000012c 0004 01020304"+ // www.example.com (pointer) A 1.2.3.4
+		" 04 6d61696c c010 0001 0001 0000003c 0004 05060708") // mail + example.com (pointer) A 5.6.7.8
+
+	reply, err := parseDNSReply(packet)
+	if err != nil {
+		t.Fatalf("failed to parse reply: %v", err)
+	}
+	if reply.id != 0x1234 || reply.rcode != 0 || len(reply.answers) != 2 || len(reply.extras) != 0 {
+		t.Fatalf("reply mismatch: %+v", reply)
+	}
+	want := []struct {
+		name string
+		ttl  uint32
+		data string
+	}{
+		{"www.example.com", 300, "01020304"},
+		{"mail.example.com", 60, "05060708"},
+	}
+	for i, rr := range reply.answers {
+		if rr.name != want[i].name || rr.rtype != dnsTypeA || rr.class != dnsClassIN || rr.ttl != want[i].ttl || hex.EncodeToString(rr.data) != want[i].data {
+			t.Errorf("answer %d mismatch: have %+v, want %+v", i, rr, want[i])
+		}
+	}
+	// Ensure every truncation of the reply is rejected
+	for size := 0; size < len(packet); size++ {
+		if reply, err := parseDNSReply(packet[:size]); err == nil {
+			t.Errorf("reply truncated to %d bytes accepted: %+v", size, reply)
+		}
+	}
+	// Ensure random corruptions of the reply don't crash the parser
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		corrupt := append([]byte{}, packet...)
+		for j := 0; j < 1+rng.Intn(4); j++ {
+			corrupt[rng.Intn(len(corrupt))] = byte(rng.Intn(256))
+		}
+		parseDNSReply(corrupt)
+	}
+}
+
+// Tests that malformed names are rejected.
+func TestParseDNSName(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet string
+		offset int
+		want   string
+		next   int
+		fail   bool
+	}{
+		{name: "root", packet: "00", want: "", next: 1},
+		{name: "plain", packet: "03 777777 03 636f6d 00", want: "www.com", next: 9},
+		{name: "pointer", packet: "03 636f6d 00 03 777777 c000", offset: 5, want: "www.com", next: 11},
+		{name: "pointer loop", packet: "c000", fail: true},
+		{name: "pointer chain loop", packet: "03 777777 c006 c000", fail: true},
+		{name: "pointer out of range", packet: "c0ff", fail: true},
+		{name: "truncated pointer", packet: "03 777777 c0", fail: true},
+		{name: "truncated label", packet: "05 7777", fail: true},
+		{name: "unterminated", packet: "03 777777", fail: true},
+		{name: "extended label", packet: "41 777777 00", fail: true},
+		{name: "offset beyond packet", packet: "00", offset: 5, fail: true},
+	}
+	for _, tt := range tests {
+		name, next, err := parseDNSName(unhex(t, tt.packet), tt.offset)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected failure, got %q", tt.name, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", tt.name, err)
+			continue
+		}
+		if name != tt.want || next != tt.next {
+			t.Errorf("%s: name mismatch: have %q/%d, want %q/%d", tt.name, name, next, tt.want, tt.next)
+		}
+	}
+}
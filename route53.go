@@ -0,0 +1,229 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// route53Endpoint is the base URL of the AWS Route53 API.
+const route53Endpoint = "https://route53.amazonaws.com/2013-04-01"
+
+// route53Provider is the AWS Route53 DNS provider, updating the record sets of
+// hosted zones through the REST API. Requests are signed with credentials from
+// the standard AWS chain (environment, shared credentials file, instance role).
+type route53Provider struct {
+	client *http.Client        // HTTP client to execute the API calls with
+	creds  *awsCredentialChain // Source of the credentials to sign requests with
+}
+
+// newRoute53Provider creates an AWS Route53 DNS provider.
+func newRoute53Provider(client *http.Client) *route53Provider {
+	return &route53Provider{client: client, creds: new(awsCredentialChain)}
+}
+
+// String implements fmt.Stringer, returning the name of the provider.
+func (p *route53Provider) String() string {
+	return "route53"
+}
+
+// route53RecordSet is a resource record set as represented by the Route53 API.
+type route53RecordSet struct {
+	Name    string          `xml:"Name"`
+	Type    string          `xml:"Type"`
+	TTL     int             `xml:"TTL,omitempty"`
+	Records []route53Record `xml:"ResourceRecords>ResourceRecord"`
+}
+
+// route53Record is a single value of a Route53 resource record set.
+type route53Record struct {
+	Value string `xml:"Value"`
+}
+
+// values returns the plain values of all the records in the set.
+func (s *route53RecordSet) values() []string {
+	values := make([]string, 0, len(s.Records))
+	for _, rec := range s.Records {
+		values = append(values, rec.Value)
+	}
+	return values
+}
+
+// verify implements provider, checking that the hosted zones of all domains are
+// accessible with the available credentials.
+func (p *route53Provider) verify(domains []*domain) error {
+	for _, host := range domains {
+		zone, err := p.resolveZone(host)
+		if err != nil {
+			if deniedAPI(err) || strings.Contains(err.Error(), "could not be found") {
+				return fmt.Errorf("hosted zone of %s not accessible: %v", host, err)
+			}
+			logErrorf("Failed to verify hosted zone of %s: %v", host, err)
+			continue
+		}
+		host.zoneID = zone
+
+		set, err := p.findRecordSet(zone, host.name, "A")
+		if err != nil {
+			if deniedAPI(err) {
+				return fmt.Errorf("records of %s not accessible: %v", host, err)
+			}
+			logErrorf("Failed to verify records of %s: %v", host, err)
+			continue
+		}
+		if set == nil && !*createFlag {
+			if set, err = p.findRecordSet(zone, host.name, "AAAA"); err == nil && set == nil {
+				logWarnf("No DNS records found for %s, use -create to add them", host)
+			}
+		}
+	}
+	return nil
+}
+
+// upsert implements provider, updating the record set of a domain to hold the
+// given address, according to the configured policy for multiple records.
+func (p *route53Provider) upsert(host *domain, kind string, address string, previous string, ttl int) (bool, error) {
+	var err error
+	if host.zoneID == "" {
+		if host.zoneID, err = p.resolveZone(host); err != nil {
+			return false, fmt.Errorf("hosted zone resolution failed: %v", err)
+		}
+	}
+	set, err := p.findRecordSet(host.zoneID, host.name, kind)
+	if err != nil {
+		host.zoneID = ""
+		return false, fmt.Errorf("record set retrieval failed: %v", err)
+	}
+	if set == nil && !*createFlag {
+		return false, fmt.Errorf("no %s record set found for %s, use -create to add it", kind, host)
+	}
+	// Assemble the new record set, Route53 having no notion of automatic TTLs
+	if host.ttl > 0 {
+		ttl = host.ttl
+	}
+	if ttl == 1 {
+		ttl = 300
+	}
+	values := []string{address}
+	if set != nil {
+		if values, err = mergeValues(set.values(), address, previous); err != nil {
+			return false, err
+		}
+		if set.TTL == ttl && strings.Join(set.values(), ",") == strings.Join(values, ",") {
+			return false, nil
+		}
+	}
+	update := &route53RecordSet{Name: host.name + ".", Type: kind, TTL: ttl}
+	for _, value := range values {
+		update.Records = append(update.Records, route53Record{Value: value})
+	}
+	// Submit the change batch, propagation to the name servers is asynchronous
+	var req struct {
+		XMLName xml.Name          `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+		Comment string            `xml:"ChangeBatch>Comment,omitempty"`
+		Action  string            `xml:"ChangeBatch>Changes>Change>Action"`
+		Set     *route53RecordSet `xml:"ChangeBatch>Changes>Change>ResourceRecordSet"`
+	}
+	req.Comment, req.Action, req.Set = recordComment(address), "UPSERT", update
+
+	body, err := xml.Marshal(&req)
+	if err != nil {
+		return false, err
+	}
+	if _, err := p.call("POST", "/hostedzone/"+host.zoneID+"/rrset", nil, body); err != nil {
+		host.zoneID = ""
+		return false, fmt.Errorf("record set update failed: %v", err)
+	}
+	if set == nil {
+		logInfof("Created missing DNS record: %s (%s)", host, kind)
+	}
+	return true, nil
+}
+
+// resolveZone retrieves the Route53 hosted zone ID of a domain, either from its
+// explicit zone setting (name or ID) or by deriving the zone from the name.
+func (p *route53Provider) resolveZone(host *domain) (string, error) {
+	// Hosted zone IDs have no dots, unlike zone names
+	if host.zone != "" && !strings.Contains(host.zone, ".") {
+		return strings.ToUpper(strings.TrimPrefix(host.zone, "/hostedzone/")), nil
+	}
+	zone, err := hostZone(host)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("dnsname", zone)
+	query.Set("maxitems", "1")
+
+	res, err := p.call("GET", "/hostedzonesbyname", query, nil)
+	if err != nil {
+		return "", err
+	}
+	var reply struct {
+		Zones []struct {
+			ID   string `xml:"Id"`
+			Name string `xml:"Name"`
+		} `xml:"HostedZones>HostedZone"`
+	}
+	if err := xml.Unmarshal(res, &reply); err != nil {
+		return "", fmt.Errorf("invalid hosted zone list: %v", err)
+	}
+	if len(reply.Zones) == 0 || normalizeName(reply.Zones[0].Name) != zone {
+		return "", fmt.Errorf("hosted zone %s could not be found", zone)
+	}
+	return strings.TrimPrefix(reply.Zones[0].ID, "/hostedzone/"), nil
+}
+
+// findRecordSet retrieves the record set of a name with the given type, or nil
+// if no such set exists.
+func (p *route53Provider) findRecordSet(zone string, name string, kind string) (*route53RecordSet, error) {
+	query := url.Values{}
+	query.Set("name", name+".")
+	query.Set("type", kind)
+	query.Set("maxitems", "1")
+
+	res, err := p.call("GET", "/hostedzone/"+zone+"/rrset", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Sets []*route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	}
+	if err := xml.Unmarshal(res, &reply); err != nil {
+		return nil, fmt.Errorf("invalid record set list: %v", err)
+	}
+	// Listing starts at the requested name, so it might return the next one
+	for _, set := range reply.Sets {
+		// Route53 returns wildcards in their octal escaped form
+		if normalizeName(strings.Replace(set.Name, `\052`, "*", 1)) == name && set.Type == kind {
+			return set, nil
+		}
+	}
+	return nil, nil
+}
+
+// call executes a signed Route53 API request.
+func (p *route53Provider) call(method string, path string, query url.Values, body []byte) ([]byte, error) {
+	endpoint := route53Endpoint + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	if simulated(method, endpoint, body) {
+		return nil, nil
+	}
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	return awsRequest(p.client, p.creds, "us-east-1", "route53", req, body)
+}
@@ -0,0 +1,42 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// readSecretFile reads a secret given via -token-file or -key-file, keeping it
+// out of the process list and the shell history. Surrounding whitespace (e.g.
+// the trailing newline left by editors) is trimmed.
+func readSecretFile(path string) (string, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(blob)), nil
+}
+
+// checkSecretFile warns if a file holding a secret is accessible by other users
+// than its owner, defeating the point of keeping it off the command line. File
+// modes carry no such meaning on Windows, so the check is skipped there, as well
+// as for Docker secrets, which are world readable within the container anyway.
+func checkSecretFile(path string) {
+	if runtime.GOOS == "windows" || strings.HasPrefix(filepath.Clean(path), dockerSecrets+"/") {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		logWarnf("Secret file %s is accessible by other users (mode %04o), consider chmod 600", path, mode)
+	}
+}
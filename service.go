@@ -0,0 +1,59 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Name and description of the updater when installed as a Windows service.
+const (
+	serviceName        = "cloudflare-dyndns"
+	serviceDisplayName = "CloudFlare Dynamic DNS Updater"
+	serviceDescription = "Keeps DNS records updated with the external address of the machine"
+)
+
+// checkServiceCommand validates the action requested via -service.
+func checkServiceCommand(command string) error {
+	switch command {
+	case "install", "uninstall", "start", "stop", "run":
+		return nil
+	default:
+		return fmt.Errorf("unknown service command: %s (use install, uninstall, start or stop)", command)
+	}
+}
+
+// serviceArgs strips the -service flag from the command line arguments, so the
+// remaining ones can be passed to the installed service, which is started with
+// -service run by the service manager.
+func serviceArgs(args []string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" || !strings.HasPrefix(args[i], "-") {
+			return append(kept, args[i:]...)
+		}
+		name := strings.TrimLeft(args[i], "-")
+		if strings.HasPrefix(name, "service=") {
+			continue
+		}
+		// Flags with separate values consume the next argument too
+		current := args[i : i+1]
+		if !strings.Contains(name, "=") && i+1 < len(args) {
+			if f := flag.Lookup(name); f != nil {
+				if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+					current = args[i : i+2]
+					i++
+				}
+			}
+		}
+		if name != "service" {
+			kept = append(kept, current...)
+		}
+	}
+	return kept
+}
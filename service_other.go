@@ -0,0 +1,29 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import "errors"
+
+// errNoService is returned when trying to manage a Windows service elsewhere.
+var errNoService = errors.New("Windows services only supported on Windows, use systemd or launchd instead")
+
+// controlService is not supported outside of Windows.
+func controlService(command string, args []string) error {
+	return errNoService
+}
+
+// startService is not supported outside of Windows.
+func startService() (<-chan struct{}, func(), error) {
+	return nil, nil, errNoService
+}
+
+// eventlogSink is not supported outside of Windows.
+func eventlogSink() (logSink, error) {
+	return nil, errors.New("event log only supported on Windows")
+}
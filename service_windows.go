@@ -0,0 +1,356 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Service control manager constants of the Windows API.
+const (
+	scManagerAllAccess      = 0xF003F // SC_MANAGER_ALL_ACCESS
+	serviceAllAccess        = 0xF01FF // SERVICE_ALL_ACCESS
+	serviceOwnProcess       = 0x10    // SERVICE_WIN32_OWN_PROCESS
+	serviceAutoStart        = 2       // SERVICE_AUTO_START
+	serviceErrorNormal      = 1       // SERVICE_ERROR_NORMAL
+	serviceConfigDesc       = 1       // SERVICE_CONFIG_DESCRIPTION
+	serviceStateStopped     = 1       // SERVICE_STOPPED
+	serviceStateStopPending = 3       // SERVICE_STOP_PENDING
+	serviceStateRunning     = 4       // SERVICE_RUNNING
+	serviceAcceptStop       = 1       // SERVICE_ACCEPT_STOP
+	serviceAcceptShut       = 4       // SERVICE_ACCEPT_SHUTDOWN
+	serviceControlStop      = 1       // SERVICE_CONTROL_STOP
+	serviceControlShut      = 5       // SERVICE_CONTROL_SHUTDOWN
+	serviceStopWaitHint     = 30000   // Milliseconds the stop may take, reported to the manager
+	errServiceNotRunning    = 1062    // ERROR_SERVICE_NOT_ACTIVE
+)
+
+// serviceStopReport is the interval of reporting the progress of a pending stop
+// to the manager, well within the wait hint so the stop isn't considered hung.
+const serviceStopReport = 5 * time.Second
+
+// Event log constants of the Windows API.
+const (
+	eventlogKey          = `SYSTEM\CurrentControlSet\Services\EventLog\Application\` + serviceName
+	eventlogMessageFile  = `%SystemRoot%\System32\EventCreate.exe`
+	eventlogErrorType    = 1 // EVENTLOG_ERROR_TYPE
+	eventlogWarningType  = 2 // EVENTLOG_WARNING_TYPE
+	eventlogInfoType     = 4 // EVENTLOG_INFORMATION_TYPE
+	hkeyLocalMachine     = 0x80000002
+	keyWrite             = 0x20006 // KEY_WRITE
+	regExpandSz          = 2       // REG_EXPAND_SZ
+	regDword             = 4       // REG_DWORD
+	eventlogMessageID    = 1       // Message of EventCreate.exe printing the event as is
+	eventlogTypesAllowed = eventlogErrorType | eventlogWarningType | eventlogInfoType
+)
+
+var (
+	procOpenSCManager          = advapi32.NewProc("OpenSCManagerW")
+	procCreateService          = advapi32.NewProc("CreateServiceW")
+	procOpenService            = advapi32.NewProc("OpenServiceW")
+	procDeleteService          = advapi32.NewProc("DeleteService")
+	procStartService           = advapi32.NewProc("StartServiceW")
+	procControlService         = advapi32.NewProc("ControlService")
+	procQueryServiceStatus     = advapi32.NewProc("QueryServiceStatus")
+	procCloseServiceHandle     = advapi32.NewProc("CloseServiceHandle")
+	procChangeServiceConfig2   = advapi32.NewProc("ChangeServiceConfig2W")
+	procStartServiceDispatcher = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceHandler = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus       = advapi32.NewProc("SetServiceStatus")
+	procRegCreateKeyEx         = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueEx          = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteKey           = advapi32.NewProc("RegDeleteKeyW")
+	procRegisterEventSource    = advapi32.NewProc("RegisterEventSourceW")
+	procReportEvent            = advapi32.NewProc("ReportEventW")
+)
+
+// winServiceStatus is the SERVICE_STATUS structure of the Windows API.
+type winServiceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// winServiceTableEntry is the SERVICE_TABLE_ENTRYW structure of the Windows API.
+type winServiceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+// controlService installs, uninstalls, starts or stops the updater's Windows
+// service. The service is installed to start automatically on boot, running the
+// current executable with the given arguments.
+func controlService(command string, args []string) error {
+	scm, _, err := procOpenSCManager.Call(0, 0, scManagerAllAccess)
+	if scm == 0 {
+		return fmt.Errorf("failed to connect to service manager: %v", err)
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	if command == "install" {
+		return installService(scm, name, args)
+	}
+	service, _, err := procOpenService.Call(scm, uintptr(unsafe.Pointer(name)), serviceAllAccess)
+	if service == 0 {
+		return fmt.Errorf("failed to open service: %v", err)
+	}
+	defer procCloseServiceHandle.Call(service)
+
+	switch command {
+	case "uninstall":
+		if res, _, err := procDeleteService.Call(service); res == 0 {
+			return err
+		}
+		procRegDeleteKey.Call(hkeyLocalMachine, uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(eventlogKey))))
+		return nil
+
+	case "start":
+		if res, _, err := procStartService.Call(service, 0, 0); res == 0 {
+			return err
+		}
+		return waitService(service, serviceStateRunning)
+
+	case "stop":
+		var status winServiceStatus
+		if res, _, err := procControlService.Call(service, serviceControlStop, uintptr(unsafe.Pointer(&status))); res == 0 {
+			if errno, ok := err.(syscall.Errno); ok && errno == errServiceNotRunning {
+				return errors.New("service not running")
+			}
+			return err
+		}
+		return waitService(service, serviceStateStopped)
+
+	default:
+		return fmt.Errorf("unknown service command: %s", command)
+	}
+}
+
+// installService creates the Windows service running the current executable and
+// registers it as an event log source.
+func installService(scm uintptr, name *uint16, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.Abs(exe); err != nil {
+		return err
+	}
+	cmdline := []string{syscall.EscapeArg(exe), "-service", "run"}
+	for _, arg := range args {
+		cmdline = append(cmdline, syscall.EscapeArg(arg))
+	}
+	display, _ := syscall.UTF16PtrFromString(serviceDisplayName)
+	binary, err := syscall.UTF16PtrFromString(strings.Join(cmdline, " "))
+	if err != nil {
+		return err
+	}
+	service, _, err := procCreateService.Call(scm, uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(display)),
+		serviceAllAccess, serviceOwnProcess, serviceAutoStart, serviceErrorNormal, uintptr(unsafe.Pointer(binary)), 0, 0, 0, 0, 0)
+	if service == 0 {
+		return err
+	}
+	defer procCloseServiceHandle.Call(service)
+
+	desc := struct{ Description *uint16 }{syscall.StringToUTF16Ptr(serviceDescription)}
+	procChangeServiceConfig2.Call(service, serviceConfigDesc, uintptr(unsafe.Pointer(&desc)))
+
+	return installEventSource()
+}
+
+// installEventSource registers the updater as an event log source, using the
+// generic messages of EventCreate.exe to display the logged events as they are.
+func installEventSource() error {
+	var key syscall.Handle
+	if res, _, _ := procRegCreateKeyEx.Call(hkeyLocalMachine, uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(eventlogKey))),
+		0, 0, 0, keyWrite, 0, uintptr(unsafe.Pointer(&key)), 0); res != 0 {
+		return fmt.Errorf("failed to register event source: %v", syscall.Errno(res))
+	}
+	defer syscall.RegCloseKey(key)
+
+	file := syscall.StringToUTF16(eventlogMessageFile)
+	if res, _, _ := procRegSetValueEx.Call(uintptr(key), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr("EventMessageFile"))),
+		0, regExpandSz, uintptr(unsafe.Pointer(&file[0])), uintptr(len(file)*2)); res != 0 {
+		return fmt.Errorf("failed to register event source: %v", syscall.Errno(res))
+	}
+	types := uint32(eventlogTypesAllowed)
+	if res, _, _ := procRegSetValueEx.Call(uintptr(key), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr("TypesSupported"))),
+		0, regDword, uintptr(unsafe.Pointer(&types)), 4); res != 0 {
+		return fmt.Errorf("failed to register event source: %v", syscall.Errno(res))
+	}
+	return nil
+}
+
+// waitService waits for a service to reach the requested state.
+func waitService(service uintptr, state uint32) error {
+	for deadline := time.Now().Add(serviceStopWaitHint * time.Millisecond); time.Now().Before(deadline); time.Sleep(250 * time.Millisecond) {
+		var status winServiceStatus
+		if res, _, err := procQueryServiceStatus.Call(service, uintptr(unsafe.Pointer(&status))); res == 0 {
+			return err
+		}
+		if status.CurrentState == state {
+			return nil
+		}
+		if status.CurrentState == serviceStateStopped {
+			return fmt.Errorf("service stopped with exit code %d, see the event log", status.Win32ExitCode)
+		}
+	}
+	return errors.New("timed out waiting for the service")
+}
+
+// serviceState is the state of the updater when running as a Windows service.
+var serviceState struct {
+	handle  uintptr       // Status handle of the service, reporting to the manager
+	started chan error    // Channel reporting whether the service started running
+	stop    chan struct{} // Channel closed when the manager requests a stop
+	done    chan struct{} // Channel closed when the updater finished stopping
+	once    sync.Once     // Guard against closing the stop channel multiple times
+
+	reporter sync.WaitGroup // Progress reporter of a pending stop, if running
+}
+
+// startService connects the updater to the Windows service manager, returning a
+// channel closed when the service is requested to stop and a function to call
+// once the updater finished stopping.
+func startService() (<-chan struct{}, func(), error) {
+	serviceState.started = make(chan error, 1)
+	serviceState.stop = make(chan struct{})
+	serviceState.done = make(chan struct{})
+
+	var (
+		exited = make(chan struct{})
+		table  = []winServiceTableEntry{
+			{syscall.StringToUTF16Ptr(serviceName), syscall.NewCallback(serviceMain)},
+			{nil, 0},
+		}
+	)
+	go func() {
+		defer close(exited)
+
+		// The dispatcher thread calls back into the service functions, pin it
+		runtime.LockOSThread()
+		if res, _, err := procStartServiceDispatcher.Call(uintptr(unsafe.Pointer(&table[0]))); res == 0 {
+			serviceState.started <- fmt.Errorf("not started by the service manager: %v", err)
+		}
+	}()
+	if err := <-serviceState.started; err != nil {
+		return nil, nil, err
+	}
+	stopped := func() {
+		close(serviceState.done)
+		<-exited
+	}
+	return serviceState.stop, stopped, nil
+}
+
+// serviceMain is the ServiceMain callback of the Windows service, registering the
+// control handler and reporting the service running until the updater stops.
+func serviceMain(argc uintptr, argv uintptr) uintptr {
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	handle, _, err := procRegisterServiceHandler.Call(uintptr(unsafe.Pointer(name)), syscall.NewCallback(serviceHandler), 0)
+	if handle == 0 {
+		serviceState.started <- fmt.Errorf("failed to register service handler: %v", err)
+		return 0
+	}
+	serviceState.handle = handle
+	setServiceStatus(handle, serviceStateRunning, 0)
+	serviceState.started <- nil
+
+	<-serviceState.done
+	serviceState.reporter.Wait()
+	setServiceStatus(handle, serviceStateStopped, 0)
+	return 0
+}
+
+// serviceHandler is the HandlerEx callback of the Windows service, relaying stop
+// and shutdown requests of the manager to the updater.
+func serviceHandler(control uintptr, event uintptr, data uintptr, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShut:
+		serviceState.once.Do(func() {
+			close(serviceState.stop)
+
+			serviceState.reporter.Add(1)
+			go reportStopping(serviceState.handle)
+		})
+	}
+	return 0
+}
+
+// reportStopping reports the service stop pending to the manager, bumping the
+// checkpoint periodically until the updater finished stopping, which may take
+// the whole shutdown grace period (and then some).
+func reportStopping(handle uintptr) {
+	defer serviceState.reporter.Done()
+
+	ticker := time.NewTicker(serviceStopReport)
+	defer ticker.Stop()
+
+	for checkpoint := uint32(1); ; checkpoint++ {
+		setServiceStatus(handle, serviceStateStopPending, checkpoint)
+		select {
+		case <-ticker.C:
+		case <-serviceState.done:
+			return
+		}
+	}
+}
+
+// setServiceStatus reports the state of the service to the manager, along with
+// the checkpoint of a pending operation's progress.
+func setServiceStatus(handle uintptr, state uint32, checkpoint uint32) {
+	status := winServiceStatus{
+		ServiceType:  serviceOwnProcess,
+		CurrentState: state,
+		CheckPoint:   checkpoint,
+	}
+	switch state {
+	case serviceStateRunning:
+		status.ControlsAccepted = serviceAcceptStop | serviceAcceptShut
+	case serviceStateStopPending:
+		status.WaitHint = serviceStopWaitHint
+	}
+	procSetServiceStatus.Call(handle, uintptr(unsafe.Pointer(&status)))
+}
+
+// eventlogSink returns a log sink writing the events into the Windows event log
+// (Application), with the type matching their level.
+func eventlogSink() (logSink, error) {
+	source, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(serviceName))))
+	if source == 0 {
+		return nil, err
+	}
+	return func(level logLevel, line string) error {
+		kind := eventlogInfoType
+		switch level {
+		case levelWarn:
+			kind = eventlogWarningType
+		case levelError:
+			kind = eventlogErrorType
+		}
+		msg, err := syscall.UTF16PtrFromString(line)
+		if err != nil {
+			return err
+		}
+		if res, _, err := procReportEvent.Call(source, uintptr(kind), 0, eventlogMessageID, 0, 1, 0, uintptr(unsafe.Pointer(&msg)), 0); res == 0 {
+			return err
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,62 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is the time the in-flight updates are given to complete after a
+// shutdown was requested, before they are aborted.
+const shutdownGrace = 30 * time.Second
+
+var (
+	// stopping is cancelled when a shutdown is requested, after which no new
+	// update rounds or resolutions are started.
+	stopping, stopUpdater = context.WithCancel(context.Background())
+
+	// aborting is cancelled when the in-flight updates are to be abandoned too:
+	// on a repeated signal or when the grace period expired. All resolutions,
+	// API calls and external commands are bound to it.
+	aborting, abortUpdater = context.WithCancel(context.Background())
+
+	shutdownOnce sync.Once // Guard against initiating the shutdown multiple times
+)
+
+// requestShutdown initiates a graceful shutdown of the updater, notifying systemd
+// and letting the in-flight updates complete within the grace period.
+func requestShutdown(reason string) {
+	shutdownOnce.Do(func() {
+		logInfof("Shutting down (%s), finishing in-flight updates", reason)
+		sdNotify("STOPPING=1")
+		stopUpdater()
+
+		time.AfterFunc(shutdownGrace, func() {
+			logWarnf("Updates still in flight after %v, aborting them", shutdownGrace)
+			abortUpdater()
+		})
+	})
+}
+
+// watchSignals requests a graceful shutdown on SIGINT or SIGTERM, aborting the
+// in-flight updates right away if the signal is repeated.
+func watchSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		requestShutdown("received " + (<-signals).String())
+
+		sig := <-signals
+		logWarnf("Received %v again, aborting in-flight updates", sig)
+		abortUpdater()
+	}()
+}
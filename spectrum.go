@@ -0,0 +1,95 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// spectrumApp is a CloudFlare Spectrum application, whose direct origins are
+// kept in sync with the external address of the default uplink.
+type spectrumApp struct {
+	zone string          // Name or ID of the zone the application belongs to
+	app  string          // Identifier of the Spectrum application
+	api  *cloudflare.API // CloudFlare client to update the application with
+}
+
+// String implements fmt.Stringer, returning a descriptive name of the app.
+func (s *spectrumApp) String() string {
+	return "spectrum app " + s.zone + "/" + s.app
+}
+
+// parseSpectrumApps parses a list of Spectrum applications in zone/app form.
+func parseSpectrumApps(specs []string) ([]*spectrumApp, error) {
+	var apps []*spectrumApp
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid spectrum application, expected zone/app: %s", spec)
+		}
+		apps = append(apps, &spectrumApp{zone: normalizeName(parts[0]), app: parts[1]})
+	}
+	return apps, nil
+}
+
+// update implements target, swapping the address of every direct origin of the
+// given family to the new one, keeping their protocols and ports.
+func (s *spectrumApp) update(address string, ipv6 bool) (bool, error) {
+	zone, err := resolveZone(s.api, &domain{name: s.zone, zone: s.zone})
+	if err != nil {
+		return false, fmt.Errorf("zone id resolution failed: %v", err)
+	}
+	endpoint := "/zones/" + zone + "/spectrum/apps/" + s.app
+
+	// Retrieve the current application config, retaining all unknown fields
+	res, err := callAPI(s.api, "GET", endpoint, nil)
+	if err != nil {
+		return false, fmt.Errorf("application retrieval failed: %v", err)
+	}
+	var app map[string]interface{}
+	if err := json.Unmarshal(res, &app); err != nil {
+		return false, fmt.Errorf("invalid application: %v", err)
+	}
+	origins, _ := app["origin_direct"].([]interface{})
+
+	var relevant, changed bool
+	for i, origin := range origins {
+		str, _ := origin.(string)
+		u, err := url.Parse(str)
+		if err != nil {
+			continue
+		}
+		host, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip == nil || (ip.To4() == nil) != ipv6 {
+			continue
+		}
+		relevant = true
+		if host != address {
+			u.Host = net.JoinHostPort(address, port)
+			origins[i], changed = u.String(), true
+		}
+	}
+	if !changed {
+		return relevant, nil
+	}
+	// Drop the read only fields and upload the updated config
+	for _, field := range []string{"id", "created_on", "modified_on"} {
+		delete(app, field)
+	}
+	if _, err := callAPI(s.api, "PUT", endpoint, app); err != nil {
+		return false, fmt.Errorf("application update failed: %v", err)
+	}
+	return true, nil
+}
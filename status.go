@@ -0,0 +1,200 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusStale is the number of update intervals after which an address not
+// resolved successfully anymore is considered stale, failing the health check.
+const statusStale = 3
+
+// familyStatus is the reported state of an address family of an uplink.
+type familyStatus struct {
+	Family   string     `json:"family"`             // Address family and the uplink it's resolved through
+	Address  string     `json:"address,omitempty"`  // Last successfully resolved address
+	Resolved *time.Time `json:"resolved,omitempty"` // Time of the last successful resolution
+	Error    string     `json:"error,omitempty"`    // Failure of the last resolution, if it failed
+	Changes  int        `json:"-"`                  // Number of times the resolved address changed
+}
+
+// domainStatus is the reported state of a single record of a domain.
+type domainStatus struct {
+	Domain  string     `json:"domain"`            // Domain (and provider if multiple) of the record
+	Type    string     `json:"type"`              // Record type (A or AAAA)
+	Address string     `json:"address,omitempty"` // Last successfully published address
+	Updated *time.Time `json:"updated,omitempty"` // Time of the last successful update
+	Error   string     `json:"error,omitempty"`   // Failure of the last update, if it failed
+
+	Successes int `json:"-"` // Number of successful updates of the record
+	Failures  int `json:"-"` // Number of failed updates of the record
+}
+
+// statusTracker collects the outcome of the resolutions and updates, reported
+// by the health endpoint so monitors can tell if the updater is actually working.
+type statusTracker struct {
+	families map[string]*familyStatus // Status of the address families by name
+	domains  map[string]*domainStatus // Status of the domain records by name and type
+	lock     sync.Mutex
+}
+
+// status is the global status tracker of the updater.
+var status = &statusTracker{
+	families: make(map[string]*familyStatus),
+	domains:  make(map[string]*domainStatus),
+}
+
+// resolved records the outcome of resolving the address of a family.
+func (t *statusTracker) resolved(family *family, address string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stat := t.families[family.String()]
+	if stat == nil {
+		stat = &familyStatus{Family: family.String()}
+		t.families[family.String()] = stat
+	}
+	if err != nil {
+		stat.Error = err.Error()
+		return
+	}
+	if stat.Address != "" && stat.Address != address {
+		stat.Changes++
+	}
+	now := time.Now()
+	stat.Address, stat.Resolved, stat.Error = address, &now, ""
+}
+
+// updated records the outcome of updating the record of a domain.
+func (t *statusTracker) updated(host *domain, kind string, address string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := host.String() + "/" + kind
+	stat := t.domains[key]
+	if stat == nil {
+		stat = &domainStatus{Domain: host.String(), Type: kind}
+		t.domains[key] = stat
+	}
+	if err != nil {
+		stat.Error = err.Error()
+		stat.Failures++
+		return
+	}
+	now := time.Now()
+	stat.Address, stat.Updated, stat.Error = address, &now, ""
+	stat.Successes++
+}
+
+// retain drops the status of all the address families and domains not maintained
+// anymore, e.g. after a configuration reload.
+func (t *statusTracker) retain(uplinks []*uplink, domains []*domain) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	families := make(map[string]bool)
+	for _, uplink := range uplinks {
+		for _, family := range uplink.families {
+			families[family.String()] = true
+		}
+	}
+	for name := range t.families {
+		if !families[name] {
+			delete(t.families, name)
+		}
+	}
+	keep := make(map[string]bool)
+	for _, host := range domains {
+		keep[host.String()] = true
+	}
+	for key, stat := range t.domains {
+		if !keep[stat.Domain] {
+			delete(t.domains, key)
+		}
+	}
+}
+
+// report assembles the current status, along with whether the updater is healthy:
+// every address family was resolved recently and no record failed to update.
+func (t *statusTracker) report() (bool, []*familyStatus, []*domainStatus) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var (
+		healthy  = len(t.families) > 0
+		families = make([]*familyStatus, 0, len(t.families))
+		domains  = make([]*domainStatus, 0, len(t.domains))
+		stale    = statusStale * *updateFlag
+	)
+	for _, stat := range t.families {
+		if stat.Resolved == nil || time.Since(*stat.Resolved) > stale+*cacheFlag {
+			healthy = false
+		}
+		snapshot := *stat
+		families = append(families, &snapshot)
+	}
+	for _, stat := range t.domains {
+		if stat.Error != "" {
+			healthy = false
+		}
+		snapshot := *stat
+		domains = append(domains, &snapshot)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Family < families[j].Family })
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].Domain != domains[j].Domain {
+			return domains[i].Domain < domains[j].Domain
+		}
+		return domains[i].Type < domains[j].Type
+	})
+	return healthy, families, domains
+}
+
+// startStatusServer starts serving the status endpoints on the given address:
+// /healthz reports the resolved addresses and the state of every record as JSON,
+// replying with 503 if the updater is not healthy, and /metrics exports the same
+// along with the resolver statistics as Prometheus metrics.
+func startStatusServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		healthy, families, domains := status.report()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"healthy":  healthy,
+			"families": families,
+			"domains":  domains,
+		})
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w)
+	})
+	go func() {
+		server := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: serverHeaderTimeout,
+			ReadTimeout:       serverReadTimeout,
+		}
+		if err := server.Serve(listener); err != nil {
+			logErrorf("Status server failed: %v", err)
+		}
+	}()
+	return nil
+}
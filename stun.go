@@ -0,0 +1,158 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	stunDefaultPort = "3478"     // Default STUN port if none was specified
+	stunMagicCookie = 0x2112A442 // Fixed value identifying RFC 5389 messages
+
+	stunBindingRequest  = 0x0001 // Message type of a binding request
+	stunBindingResponse = 0x0101 // Message type of a binding success response
+
+	stunAttrMappedAddress    = 0x0001 // Plain mapped address attribute (RFC 3489)
+	stunAttrXorMappedAddress = 0x0020 // Obfuscated mapped address attribute (RFC 5389)
+
+	stunAttempts = 3               // Number of binding requests to send before giving up
+	stunTimeout  = 2 * time.Second // Time to wait for a reply to a single request
+)
+
+// querySTUN retrieves the external IP address of the machine by sending an RFC
+// 5389 binding request to a STUN server and parsing the reflexive address out
+// of the reply.
+func querySTUN(ctx context.Context, dial dialFunc, server string, ipv6 bool) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, stunDefaultPort)
+	}
+	network := "udp4"
+	if ipv6 {
+		network = "udp6"
+	}
+	conn, err := dial(ctx, network, server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	// Assemble the binding request with a random transaction id
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint16(request[2:], 0)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return "", err
+	}
+	// Send the request a few times since UDP may drop it along the way
+	reply := make([]byte, 1500)
+	for i := 0; i < stunAttempts; i++ {
+		if _, err = conn.Write(request); err != nil {
+			return "", err
+		}
+		conn.SetReadDeadline(time.Now().Add(stunTimeout))
+
+		var n int
+		if n, err = conn.Read(reply); err != nil {
+			continue
+		}
+		ip, err := parseSTUNResponse(reply[:n], request[8:20])
+		if err != nil {
+			return "", err
+		}
+		return ip.String(), nil
+	}
+	return "", err
+}
+
+// parseSTUNResponse extracts the reflexive address from a STUN binding response,
+// preferring the XOR-ed address attribute over the old plain one.
+func parseSTUNResponse(reply []byte, txid []byte) (net.IP, error) {
+	// Validate the response header against our request
+	if len(reply) < 20 {
+		return nil, errors.New("short stun response")
+	}
+	if kind := binary.BigEndian.Uint16(reply[0:]); kind != stunBindingResponse {
+		return nil, fmt.Errorf("unexpected stun response type: %#04x", kind)
+	}
+	if !bytes.Equal(reply[8:20], txid) {
+		return nil, errors.New("stun transaction id mismatch")
+	}
+	size := int(binary.BigEndian.Uint16(reply[2:]))
+	if len(reply) < 20+size {
+		return nil, errors.New("truncated stun response")
+	}
+	// Iterate over the attributes and look for the mapped addresses
+	var mapped net.IP
+	for attrs := reply[20 : 20+size]; len(attrs) >= 4; {
+		kind := binary.BigEndian.Uint16(attrs[0:])
+		length := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+length {
+			return nil, errors.New("truncated stun attribute")
+		}
+		value := attrs[4 : 4+length]
+
+		switch kind {
+		case stunAttrXorMappedAddress:
+			ip, err := parseSTUNAddress(value)
+			if err != nil {
+				return nil, err
+			}
+			// Undo the obfuscation: XOR with the magic cookie and transaction id
+			for i := range ip {
+				ip[i] ^= reply[4+i]
+			}
+			return ip, nil
+
+		case stunAttrMappedAddress:
+			ip, err := parseSTUNAddress(value)
+			if err != nil {
+				return nil, err
+			}
+			mapped = ip
+		}
+		// Attributes are padded to 4 byte boundaries
+		if padded := (4 + length + 3) &^ 3; padded < len(attrs) {
+			attrs = attrs[padded:]
+		} else {
+			break
+		}
+	}
+	if mapped == nil {
+		return nil, errors.New("no mapped address in stun response")
+	}
+	return mapped, nil
+}
+
+// parseSTUNAddress parses the raw IP address out of a (XOR-)MAPPED-ADDRESS
+// attribute value.
+func parseSTUNAddress(value []byte) (net.IP, error) {
+	if len(value) < 4 {
+		return nil, errors.New("short stun address")
+	}
+	switch value[1] {
+	case 0x01:
+		if len(value) < 8 {
+			return nil, errors.New("short stun ipv4 address")
+		}
+		return net.IP(append([]byte{}, value[4:8]...)), nil
+	case 0x02:
+		if len(value) < 20 {
+			return nil, errors.New("short stun ipv6 address")
+		}
+		return net.IP(append([]byte{}, value[4:20]...)), nil
+	default:
+		return nil, fmt.Errorf("unknown stun address family: %d", value[1])
+	}
+}
@@ -0,0 +1,102 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"testing"
+)
+
+// Tests that STUN binding responses are parsed correctly, using the sample
+// responses of RFC 5769 (sections 2.2 and 2.3, without the integrity attributes).
+func TestParseSTUNResponse(t *testing.T) {
+	var (
+		txid     = "b7e7a701 bc34d686 fa87dfae"
+		software = "8022000b 74657374 20766563 746f7220" // SOFTWARE "test vector", padded
+	)
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+		fail  bool
+	}{
+		{
+			name:  "rfc 5769 ipv4",
+			reply: "0101001c 2112a442 " + txid + software + " 00200008 0001a147 e112a643",
+			want:  "192.0.2.1",
+		},
+		{
+			name:  "rfc 5769 ipv6",
+			reply: "01010028 2112a442 " + txid + software + " 00200014 0002a147 0113a9fa a5d3f179 bc25f4b5 bed2b9d9",
+			want:  "2001:db8:1234:5678:11:2233:4455:6677",
+		},
+		{
+			name:  "plain mapped address",
+			reply: "0101000c 2112a442 " + txid + " 00010008 00010d96 c0000201",
+			want:  "192.0.2.1",
+		},
+		{
+			name:  "xor mapped address preferred",
+			reply: "01010018 2112a442 " + txid + " 00010008 00010d96 c6336401 00200008 0001a147 e112a643",
+			want:  "192.0.2.1",
+		},
+		{
+			name:  "error response",
+			reply: "01110000 2112a442 " + txid,
+			fail:  true,
+		},
+		{
+			name:  "transaction id mismatch",
+			reply: "0101000c 2112a442 b7e7a701 bc34d686 fa87dfaf 00200008 0001a147 e112a643",
+			fail:  true,
+		},
+		{
+			name:  "short header",
+			reply: "0101000c 2112a442 b7e7a701",
+			fail:  true,
+		},
+		{
+			name:  "truncated message",
+			reply: "01010010 2112a442 " + txid + " 00200008 0001a147 e112a643",
+			fail:  true,
+		},
+		{
+			name:  "truncated attribute",
+			reply: "01010008 2112a442 " + txid + " 0020000c 0001a147",
+			fail:  true,
+		},
+		{
+			name:  "short address",
+			reply: "0101000c 2112a442 " + txid + " 00200004 0001a147 e112a643",
+			fail:  true,
+		},
+		{
+			name:  "unknown family",
+			reply: "0101000c 2112a442 " + txid + " 00200008 0003a147 e112a643",
+			fail:  true,
+		},
+		{
+			name:  "no mapped address",
+			reply: "01010010 2112a442 " + txid + software,
+			fail:  true,
+		},
+	}
+	for _, tt := range tests {
+		ip, err := parseSTUNResponse(unhex(t, tt.reply), unhex(t, txid))
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected failure, got %v", tt.name, ip)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", tt.name, err)
+			continue
+		}
+		if ip.String() != tt.want {
+			t.Errorf("%s: address mismatch: have %v, want %v", tt.name, ip, tt.want)
+		}
+	}
+}
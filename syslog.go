@@ -0,0 +1,82 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net"
+	"strings"
+)
+
+// syslogTag is the program name the log events are tagged with in syslog.
+const syslogTag = "cloudflare-dyndns"
+
+// syslogFacilities maps the facility names to their syslog priorities.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// syslogSink connects to the local syslog daemon, or to a remote syslog server
+// via UDP (default) or TCP on port 514 unless specified, returning a log sink
+// writing the events with the severity matching their level.
+func syslogSink(target string, facility string) (logSink, error) {
+	priority, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %s", facility)
+	}
+	var network, addr string
+	if target != "local" {
+		network, addr = "udp", target
+		if index := strings.Index(target, "://"); index >= 0 {
+			network, addr = target[:index], target[index+3:]
+			if network != "udp" && network != "tcp" {
+				return nil, fmt.Errorf("unsupported syslog protocol: %s", network)
+			}
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "514")
+		}
+	}
+	writer, err := syslog.Dial(network, addr, priority|syslog.LOG_INFO, syslogTag)
+	if err != nil {
+		return nil, err
+	}
+	return func(level logLevel, line string) error {
+		switch level {
+		case levelDebug:
+			return writer.Debug(line)
+		case levelInfo:
+			return writer.Info(line)
+		case levelWarn:
+			return writer.Warning(line)
+		default:
+			return writer.Err(line)
+		}
+	}, nil
+}
@@ -0,0 +1,16 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "errors"
+
+// syslogSink is not supported on platforms without syslog.
+func syslogSink(target string, facility string) (logSink, error) {
+	return nil, errors.New("syslog not supported on this platform")
+}
@@ -0,0 +1,22 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+// triggers delivers the requests for an immediate update round to the update
+// loop (e.g. right after a known reconnect). It is buffered for a single pending
+// request, further ones coalescing into it until the round starts.
+var triggers = make(chan string, 1)
+
+// triggerUpdate requests an immediate update round, returning whether it was
+// queued or one was already pending.
+func triggerUpdate(reason string) bool {
+	select {
+	case triggers <- reason:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,10 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+// watchTriggerSignal does nothing on Windows, lacking SIGUSR1. Immediate updates
+// can be requested via the push receiver's /trigger endpoint instead.
+func watchTriggerSignal() {}
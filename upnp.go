@@ -0,0 +1,204 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddress = "239.255.255.250:1900" // Multicast address of the SSDP discovery protocol
+	ssdpTimeout = 3 * time.Second        // Time to wait for gateways to answer discovery
+
+	upnpTimeout = 5 * time.Second // Time to wait for a gateway to answer a SOAP call
+)
+
+// upnpDeviceTypes are the UPnP device types searched for during discovery.
+var upnpDeviceTypes = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+}
+
+// upnpServiceTypes are the UPnP service types capable of reporting the external
+// address of the gateway.
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpRoot is the root of a UPnP device description document.
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// upnpDevice is a single (possibly embedded) device of a UPnP description.
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+// upnpService is a single service exposed by a UPnP device.
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// queryUPnP retrieves the external IP address of the machine by asking the local
+// gateway via its UPnP Internet Gateway Device service.
+func queryUPnP() (string, error) {
+	location, err := discoverUPnP()
+	if err != nil {
+		return "", err
+	}
+	control, service, err := findUPnPService(location)
+	if err != nil {
+		return "", err
+	}
+	var reply struct {
+		Address string `xml:"NewExternalIPAddress"`
+	}
+	if err := soapCall(control, service, "GetExternalIPAddress", &reply); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(reply.Address), nil
+}
+
+// discoverUPnP multicasts an SSDP search for internet gateway devices on the local
+// network, returning the description location of the first one answering.
+func discoverUPnP() (string, error) {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	for _, kind := range upnpDeviceTypes {
+		request := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddress + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n" +
+			"ST: " + kind + "\r\n\r\n"
+
+		if _, err := conn.WriteTo([]byte(request), group); err != nil {
+			return "", err
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(ssdpTimeout))
+
+	buffer := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return "", fmt.Errorf("no upnp gateway found: %v", err)
+		}
+		reply, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buffer[:n])), nil)
+		if err != nil {
+			continue
+		}
+		reply.Body.Close()
+
+		if location := reply.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// findUPnPService retrieves the description of a gateway device and looks up the
+// control endpoint of the first service able to report the external address.
+func findUPnPService(location string) (string, string, error) {
+	client := &http.Client{Timeout: upnpTimeout}
+
+	reply, err := client.Get(location)
+	if err != nil {
+		return "", "", err
+	}
+	defer reply.Body.Close()
+
+	var root upnpRoot
+	if err := xml.NewDecoder(reply.Body).Decode(&root); err != nil {
+		return "", "", err
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if root.URLBase != "" {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return "", "", err
+		}
+	}
+	// Walk the device tree looking for a suitable service
+	devices := []upnpDevice{root.Device}
+	for len(devices) > 0 {
+		device := devices[0]
+		devices = append(devices[1:], device.Devices...)
+
+		for _, service := range device.Services {
+			for _, kind := range upnpServiceTypes {
+				if service.ServiceType == kind {
+					control, err := base.Parse(service.ControlURL)
+					if err != nil {
+						return "", "", err
+					}
+					return control.String(), kind, nil
+				}
+			}
+		}
+	}
+	return "", "", errors.New("no wan connection service found")
+}
+
+// soapCall invokes an argument-less action of a UPnP service via SOAP, decoding
+// the response arguments into result.
+func soapCall(control string, service string, action string, result interface{}) error {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + service + `"/></s:Body>` +
+		`</s:Envelope>`
+
+	req, err := http.NewRequest("POST", control, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service+"#"+action+`"`)
+
+	client := &http.Client{Timeout: upnpTimeout}
+
+	reply, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return fmt.Errorf("soap %s failed: %s", action, reply.Status)
+	}
+	var envelope struct {
+		Body struct {
+			Inner []byte `xml:",innerxml"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(reply.Body).Decode(&envelope); err != nil {
+		return err
+	}
+	return xml.Unmarshal(envelope.Body.Inner, result)
+}
@@ -0,0 +1,174 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultKubernetesToken is the service account token mounted into Kubernetes pods,
+// used to authenticate with the Vault kubernetes auth method.
+const vaultKubernetesToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultSecret is a HashiCorp Vault secret holding the CloudFlare API token, read
+// via the HTTP API and authenticated with one of the standard auth methods. The
+// address, namespace and auth parameters are taken from the same environment
+// variables the vault CLI uses.
+type vaultSecret struct {
+	client *http.Client // HTTP client to execute the API calls with
+	addr   string       // Base URL of the Vault server
+	path   string       // API path of the secret (e.g. secret/data/cloudflare)
+	field  string       // Field of the secret holding the token
+	auth   string       // Auth method to log in with (token, approle, kubernetes)
+
+	token   string    // Client token to authenticate the reads with
+	expires time.Time // Expiration of the client token if logged in (zero = never)
+	lock    sync.Mutex
+}
+
+// newVaultSecret creates a Vault token source for a secret given as path#field,
+// with the field defaulting to token.
+func newVaultSecret(client *http.Client, secret string, auth string) (*vaultSecret, error) {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, errors.New("no Vault server specified, set $VAULT_ADDR")
+	}
+	path, field := secret, "token"
+	if index := strings.LastIndex(secret, "#"); index >= 0 {
+		path, field = secret[:index], secret[index+1:]
+	}
+	path = strings.TrimPrefix(strings.Trim(path, "/"), "v1/")
+	if path == "" || field == "" {
+		return nil, fmt.Errorf("invalid Vault secret, expected path#field: %s", secret)
+	}
+	v := &vaultSecret{client: client, addr: addr, path: path, field: field, auth: auth}
+	switch auth {
+	case "token":
+		if v.token = os.Getenv("VAULT_TOKEN"); v.token == "" {
+			// Fall back to the token helper file of the vault CLI
+			home, _ := os.UserHomeDir()
+			blob, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+			if err != nil {
+				return nil, errors.New("no Vault token found, set $VAULT_TOKEN or log in via the vault CLI")
+			}
+			v.token = strings.TrimSpace(string(blob))
+		}
+	case "approle":
+		if os.Getenv("VAULT_ROLE_ID") == "" || os.Getenv("VAULT_SECRET_ID") == "" {
+			return nil, errors.New("incomplete AppRole credentials, set $VAULT_ROLE_ID and $VAULT_SECRET_ID")
+		}
+	case "kubernetes":
+		if os.Getenv("VAULT_K8S_ROLE") == "" {
+			return nil, errors.New("no Kubernetes auth role specified, set $VAULT_K8S_ROLE")
+		}
+	default:
+		return nil, fmt.Errorf("unknown Vault auth method: %s", auth)
+	}
+	return v, nil
+}
+
+// load implements a credentials token source, reading the secret from Vault and
+// returning the token field. Login based auth methods log in on first use and
+// again whenever the client token expired or was revoked. Loads are serialized,
+// as the credential watcher and reloads may read the secret concurrently.
+func (v *vaultSecret) load() (string, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.auth != "token" && (v.token == "" || (!v.expires.IsZero() && time.Now().After(v.expires))) {
+		if err := v.login(); err != nil {
+			return "", err
+		}
+	}
+	var reply struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err := jsonCall(v.client, "GET", v.addr+"/v1/"+v.path, v.header(), nil, &reply)
+	if err != nil && v.auth != "token" && deniedAPI(err) {
+		// The client token might have been revoked, retry with a fresh one
+		if err = v.login(); err == nil {
+			err = jsonCall(v.client, "GET", v.addr+"/v1/"+v.path, v.header(), nil, &reply)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("vault read of %s failed: %v", v.path, err)
+	}
+	// KV version 2 secrets nest the fields along with their metadata
+	fields := reply.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		if _, ok := fields["metadata"]; ok {
+			fields = nested
+		}
+	}
+	value, ok := fields[v.field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no %s field", v.path, v.field)
+	}
+	return value, nil
+}
+
+// login authenticates with the configured auth method, replacing the client token.
+func (v *vaultSecret) login() error {
+	var (
+		mount   string
+		payload map[string]string
+	)
+	switch v.auth {
+	case "approle":
+		mount = "approle"
+		payload = map[string]string{"role_id": os.Getenv("VAULT_ROLE_ID"), "secret_id": os.Getenv("VAULT_SECRET_ID")}
+
+	case "kubernetes":
+		mount = "kubernetes"
+		jwt, err := ioutil.ReadFile(vaultKubernetesToken)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %v", err)
+		}
+		payload = map[string]string{"role": os.Getenv("VAULT_K8S_ROLE"), "jwt": strings.TrimSpace(string(jwt))}
+	}
+	if path := os.Getenv("VAULT_AUTH_PATH"); path != "" {
+		mount = strings.Trim(path, "/")
+	}
+	var reply struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	v.token = ""
+	if err := jsonCall(v.client, "POST", v.addr+"/v1/auth/"+mount+"/login", v.header(), payload, &reply); err != nil {
+		return fmt.Errorf("vault %s login failed: %v", v.auth, err)
+	}
+	if reply.Auth.ClientToken == "" {
+		return fmt.Errorf("vault %s login returned no token", v.auth)
+	}
+	v.token, v.expires = reply.Auth.ClientToken, time.Time{}
+	if reply.Auth.LeaseDuration > 0 {
+		// Log in again a bit early, before the token actually expires
+		v.expires = time.Now().Add(time.Duration(reply.Auth.LeaseDuration) * time.Second * 9 / 10)
+	}
+	return nil
+}
+
+// header returns the headers authenticating a Vault API call.
+func (v *vaultSecret) header() http.Header {
+	header := make(http.Header)
+	if v.token != "" {
+		header.Set("X-Vault-Token", v.token)
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		header.Set("X-Vault-Namespace", namespace)
+	}
+	return header
+}
@@ -0,0 +1,209 @@
+// CloudFlare Dynamic DNS Updater
+// Copyright (c) 2015 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// wizardRecord is a DNS record offered for management by the setup wizard.
+type wizardRecord struct {
+	name string // Fully qualified name of the record
+	kind string // Type of the record (A or AAAA)
+}
+
+// runWizard interactively assembles a configuration: it asks for a CloudFlare API
+// token, lists the address records of the zones it can access, lets the user pick
+// the ones to maintain, and writes a ready to use config file (with the token in
+// a separate file) and a systemd unit next to it.
+func runWizard(client *http.Client, in io.Reader, out io.Writer) error {
+	input := bufio.NewReader(in)
+
+	fmt.Fprintln(out, "CloudFlare Dynamic DNS Updater setup")
+	fmt.Fprintln(out)
+
+	// Retrieve and verify the token, unless already configured
+	token := *tokenFlag
+	if token == "" {
+		fmt.Fprintln(out, "Create a scoped API token with the Zone:Read and DNS:Edit permissions at")
+		fmt.Fprintln(out, "https://dash.cloudflare.com/profile/api-tokens, then paste it here.")
+		fmt.Fprintln(out)
+
+		var err error
+		if token, err = prompt(input, out, "API token", ""); err != nil {
+			return err
+		}
+		if token == "" {
+			return errors.New("no API token given")
+		}
+	}
+	creds, err := newCredentials(token, "", "", "", "")
+	if err != nil {
+		return err
+	}
+	provider, err := newCloudflareProvider(creds, client)
+	if err != nil {
+		return err
+	}
+	if err := provider.verify(nil); err != nil {
+		return err
+	}
+	// List all the address records the token can access
+	ctx, cancel := apiContext()
+	zones, err := provider.api.ListZonesContext(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("zone listing failed: %v", err)
+	}
+	if len(zones.Result) == 0 {
+		return errors.New("no zones accessible with the token, check its permissions")
+	}
+	var records []wizardRecord
+	for _, zone := range zones.Result {
+		for _, kind := range []string{"A", "AAAA"} {
+			recs, err := listRecords(provider.api, zone.ID, cloudflare.ListDNSRecordsParams{Type: kind})
+			if err != nil {
+				return fmt.Errorf("record listing of %s failed: %v", zone.Name, err)
+			}
+			for _, rec := range recs {
+				records = append(records, wizardRecord{name: rec.Name, kind: rec.Type})
+			}
+		}
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Address records found:")
+	for i, rec := range records {
+		fmt.Fprintf(out, "  %3d) %-5s %s\n", i+1, rec.kind, rec.name)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Pick the records to maintain by number, or enter new names to create (comma separated).")
+
+	// Collect the picked domains, deduplicating the ones with both record types
+	answer, err := prompt(input, out, "Records", "")
+	if err != nil {
+		return err
+	}
+	var (
+		domains []string
+		ipv4    bool
+		ipv6    bool
+		create  bool
+	)
+	for _, pick := range splitList(answer) {
+		if index, err := strconv.Atoi(pick); err == nil {
+			if index < 1 || index > len(records) {
+				return fmt.Errorf("no record #%d", index)
+			}
+			rec := records[index-1]
+			if !contains(domains, rec.name) {
+				domains = append(domains, rec.name)
+			}
+			ipv4, ipv6 = ipv4 || rec.kind == "A", ipv6 || rec.kind == "AAAA"
+			continue
+		}
+		if name := normalizeName(pick); !contains(domains, name) {
+			domains = append(domains, name)
+			ipv4, create = true, true
+		}
+	}
+	if len(domains) == 0 {
+		return errors.New("no records picked")
+	}
+	interval, err := prompt(input, out, "Update interval", updateFlag.String())
+	if err != nil {
+		return err
+	}
+	path, err := prompt(input, out, "Config file to write", "cloudflare-dyndns.toml")
+	if err != nil {
+		return err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+	// Write out the token, the config and the systemd unit
+	tokenPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".token"
+	if err := ioutil.WriteFile(tokenPath, []byte(token+"\n"), 0600); err != nil {
+		return err
+	}
+	config := new(strings.Builder)
+	fmt.Fprintf(config, "# Generated by cloudflare-dyndns init\n")
+	fmt.Fprintf(config, "token-file = %q\n", tokenPath)
+	fmt.Fprintf(config, "update     = %q\n", interval)
+	fmt.Fprintf(config, "ipv4       = %v\n", ipv4)
+	fmt.Fprintf(config, "ipv6       = %v\n", ipv6)
+	if create {
+		fmt.Fprintf(config, "create     = true\n")
+	}
+	for _, name := range domains {
+		fmt.Fprintf(config, "\n[[domain]]\nname = %q\n", name)
+	}
+	if err := ioutil.WriteFile(path, []byte(config.String()), 0600); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		executable = "/usr/local/bin/cloudflare-dyndns"
+	}
+	unitPath := filepath.Join(filepath.Dir(path), "cloudflare-dyndns.service")
+	unit := fmt.Sprintf(wizardUnit, executable, path)
+	if err := ioutil.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Wrote %s (token in %s)\n", path, tokenPath)
+	fmt.Fprintf(out, "Wrote %s\n", unitPath)
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Test the setup with:    %s -config %s validate\n", executable, path)
+	fmt.Fprintf(out, "Install the service by: sudo cp %s /etc/systemd/system/ && sudo systemctl enable --now cloudflare-dyndns\n", unitPath)
+	return nil
+}
+
+// wizardUnit is the template of the systemd unit written by the setup wizard,
+// taking the path of the executable and the config file.
+const wizardUnit = `[Unit]
+Description=CloudFlare Dynamic DNS Updater
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s -config %s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// prompt asks the user for a single line of input, returning the default value
+// if nothing was entered.
+func prompt(input *bufio.Reader, out io.Writer, question string, value string) (string, error) {
+	if value != "" {
+		fmt.Fprintf(out, "%s [%s]: ", question, value)
+	} else {
+		fmt.Fprintf(out, "%s: ", question)
+	}
+	line, err := input.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read answer: %v", err)
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		return line, nil
+	}
+	return value, nil
+}